@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+	"mongokube/pkg/connect"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/duration"
+)
+
+func listCommand(flags *flag.FlagSet) func(ctx context.Context, p *plugin, args []string) error {
+	allNamespaces := flags.Bool("A", false, "List Mk resources in all namespaces")
+
+	return func(ctx context.Context, p *plugin, args []string) error {
+		namespace := p.namespace
+		if *allNamespaces {
+			namespace = metav1.NamespaceAll
+		}
+
+		mkList, err := p.mkClient.MongokubeBeta1().Mks(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(p.out, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "NAMESPACE\tNAME\tSTATUS\tREADY\tIMAGE\tAGE")
+		for _, mkResource := range mkList.Items {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d/%d\t%s\t%s\n",
+				mkResource.Namespace,
+				mkResource.Name,
+				mkResource.Status.Progress,
+				mkResource.Status.ReadyReplicas,
+				mkResource.Replicas(),
+				mkResource.Spec.MongoDbImage,
+				age(mkResource.CreationTimestamp),
+			)
+		}
+		return w.Flush()
+	}
+}
+
+func describeCommand(flags *flag.FlagSet) func(ctx context.Context, p *plugin, args []string) error {
+	return func(ctx context.Context, p *plugin, args []string) error {
+		mkResource, err := p.mkClient.MongokubeBeta1().Mks(p.namespace).Get(ctx, args[0], metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(p.out, 0, 8, 1, ' ', 0)
+		field := func(name string, value interface{}) {
+			// unset fields, false included, are left out
+			if !reflect.ValueOf(value).IsZero() {
+				fmt.Fprintf(w, "%s:\t%v\n", name, value)
+			}
+		}
+		field("Name", mkResource.Name)
+		field("Namespace", mkResource.Namespace)
+		field("Status", mkResource.Status.Progress)
+		field("Members", fmt.Sprintf("%d ready of %d", mkResource.Status.ReadyReplicas, mkResource.Replicas()))
+		field("MongoDB image", mkResource.Spec.MongoDbImage)
+		field("Running image", mkResource.Status.MongoDbImage)
+		field("Upgrade", mkResource.Status.Upgrade)
+		field("Mongo Express image", mkResource.Spec.MongoExpressImage)
+		field("Paused", mkResource.Paused())
+		field("Maintenance", mkResource.InMaintenance())
+		field("Initialization", mkResource.Status.Initialization)
+		if mkResource.Status.Binding != nil {
+			field("Binding secret", mkResource.Status.Binding.Name)
+		}
+		if mkResource.Status.Plan != nil {
+			field("Planned changes", strings.Join(mkResource.Status.Plan.Changes, ", "))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		eventList, err := p.kubeClient.CoreV1().Events(mkResource.Namespace).List(ctx, metav1.ListOptions{
+			FieldSelector: fields.OneTermEqualSelector("involvedObject.uid", string(mkResource.UID)).String(),
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(p.out, "\nEvents:")
+		w = tabwriter.NewWriter(p.out, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "  TYPE\tREASON\tAGE\tMESSAGE")
+		for _, event := range eventList.Items {
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", event.Type, event.Reason, age(event.LastTimestamp), event.Message)
+		}
+		return w.Flush()
+	}
+}
+
+func connectCommand(flags *flag.FlagSet) func(ctx context.Context, p *plugin, args []string) error {
+	portForward := flags.Bool("port-forward", false, "Forward a local port to the primary and print a connection string for it")
+	localPort := flags.Uint("local-port", connect.MongoDbPort, "Local port used by --port-forward, any free port if 0")
+
+	return func(ctx context.Context, p *plugin, args []string) error {
+		if !*portForward {
+			binding, err := p.bindingSecret(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(p.out, string(binding.Data["uri"]))
+			return nil
+		}
+
+		forward, username, password, err := p.forwardToPrimary(ctx, args[0], uint16(*localPort))
+		if err != nil {
+			return err
+		}
+		defer forward.Close()
+
+		fmt.Fprintln(p.out, forward.URI(username, password))
+		fmt.Fprintln(os.Stderr, "Forwarding, press Ctrl+C to stop")
+		select {
+		case err := <-forward.Done():
+			return err
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func shellCommand(flags *flag.FlagSet) func(ctx context.Context, p *plugin, args []string) error {
+	localPort := flags.Uint("local-port", 0, "Local port of the port-forward, any free port if 0")
+
+	return func(ctx context.Context, p *plugin, args []string) error {
+		// Ctrl+C interrupts the statement running in mongosh, it must not stop the forward
+		ctx = context.WithoutCancel(ctx)
+
+		forward, username, password, err := p.forwardToPrimary(ctx, args[0], uint16(*localPort))
+		if err != nil {
+			return err
+		}
+		defer forward.Close()
+
+		return forward.Shell(ctx, username, password, os.Stdin, os.Stdout, os.Stderr)
+	}
+}
+
+// Forward a local port to the primary of the Mk resource, returns the credentials of the root user with it
+func (p *plugin) forwardToPrimary(ctx context.Context, name string, localPort uint16) (*connect.Forward, string, string, error) {
+	mkResource, pod, err := p.connector.PrimaryPod(ctx, p.namespace, name)
+	if err != nil {
+		return nil, "", "", err
+	}
+	username, password, err := p.connector.Credentials(ctx, mkResource)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	forward, err := p.connector.PortForward(ctx, pod, localPort, os.Stderr)
+	if err != nil {
+		return nil, "", "", err
+	}
+	return forward, username, password, nil
+}
+
+func credentialsCommand(flags *flag.FlagSet) func(ctx context.Context, p *plugin, args []string) error {
+	return func(ctx context.Context, p *plugin, args []string) error {
+		binding, err := p.bindingSecret(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(p.out, "username: %s\npassword: %s\n", binding.Data["username"], binding.Data["password"])
+		return nil
+	}
+}
+
+func backupCommand(flags *flag.FlagSet) func(ctx context.Context, p *plugin, args []string) error {
+	output := flags.String("o", "", "File the archive is written to, <name>-<time>.archive.gz if empty")
+
+	return func(ctx context.Context, p *plugin, args []string) error {
+		mkResource, err := p.mkClient.MongokubeBeta1().Mks(p.namespace).Get(ctx, args[0], metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		pod, err := p.readyPod(ctx, mkResource)
+		if err != nil {
+			return err
+		}
+
+		file := *output
+		if file == "" {
+			file = fmt.Sprintf("%s-%s.archive.gz", mkResource.Name, time.Now().UTC().Format("20060102T150405Z"))
+		}
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		err = p.exec(ctx, mkResource, pod, mongoToolCommand("mongodump", "--archive", "--gzip", "--oplog"), nil, f)
+		if err != nil {
+			os.Remove(file)
+			return fmt.Errorf("mongodump in pod %s failed: %w", pod, err)
+		}
+		fmt.Fprintf(os.Stderr, "Wrote backup of %s to %s\n", mkResource.Name, file)
+		return f.Close()
+	}
+}
+
+func restoreCommand(flags *flag.FlagSet) func(ctx context.Context, p *plugin, args []string) error {
+	file := flags.String("f", "", "Archive written by backup now")
+	drop := flags.Bool("drop", false, "Drop each collection before restoring it")
+
+	return func(ctx context.Context, p *plugin, args []string) error {
+		if *file == "" {
+			return fmt.Errorf("-f is required")
+		}
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		mkResource, err := p.mkClient.MongokubeBeta1().Mks(p.namespace).Get(ctx, args[0], metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		pod, err := p.readyPod(ctx, mkResource)
+		if err != nil {
+			return err
+		}
+
+		tool := []string{"mongorestore", "--archive", "--gzip", "--oplogReplay"}
+		if *drop {
+			tool = append(tool, "--drop")
+		}
+		if err := p.exec(ctx, mkResource, pod, mongoToolCommand(tool...), f, os.Stderr); err != nil {
+			return fmt.Errorf("mongorestore in pod %s failed: %w", pod, err)
+		}
+		fmt.Fprintf(os.Stderr, "Restored %s into %s\n", *file, mkResource.Name)
+		return nil
+	}
+}
+
+func pauseCommand(paused bool) func(flags *flag.FlagSet) func(ctx context.Context, p *plugin, args []string) error {
+	return func(flags *flag.FlagSet) func(ctx context.Context, p *plugin, args []string) error {
+		return func(ctx context.Context, p *plugin, args []string) error {
+			// The annotation pauses as well, so it is removed on resume
+			patch := map[string]interface{}{"spec": map[string]interface{}{"paused": paused}}
+			if !paused {
+				patch["metadata"] = map[string]interface{}{"annotations": map[string]interface{}{beta1.PausedAnnotation: nil}}
+			}
+			if err := p.patch(ctx, args[0], patch); err != nil {
+				return err
+			}
+
+			if paused {
+				fmt.Fprintf(p.out, "mk/%s paused\n", args[0])
+			} else {
+				fmt.Fprintf(p.out, "mk/%s resumed\n", args[0])
+			}
+			return nil
+		}
+	}
+}
+
+func upgradeCommand(flags *flag.FlagSet) func(ctx context.Context, p *plugin, args []string) error {
+	image := flags.String("image", "", "New MongoDB image, e.g. mongo:7.0.5")
+
+	return func(ctx context.Context, p *plugin, args []string) error {
+		if *image == "" {
+			return fmt.Errorf("--image is required")
+		}
+		if err := p.patch(ctx, args[0], map[string]interface{}{"spec": map[string]interface{}{"mongoDbImage": *image}}); err != nil {
+			return err
+		}
+		fmt.Fprintf(p.out, "mk/%s upgrading to %s, follow it with kubectl mk describe %s\n", args[0], *image, args[0])
+		return nil
+	}
+}
+
+// Merge patch of a Mk resource
+func (p *plugin) patch(ctx context.Context, name string, patch map[string]interface{}) error {
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	_, err = p.mkClient.MongokubeBeta1().Mks(p.namespace).Patch(ctx, name, types.MergePatchType, data, metav1.PatchOptions{})
+	return err
+}
+
+// Secret with the connection details published by the controller
+func (p *plugin) bindingSecret(ctx context.Context, name string) (*v1.Secret, error) {
+	mkResource, err := p.mkClient.MongokubeBeta1().Mks(p.namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if mkResource.Status.Binding == nil {
+		return nil, fmt.Errorf("mk/%s has no binding secret yet, its status is %q", name, mkResource.Status.Progress)
+	}
+	return p.kubeClient.CoreV1().Secrets(p.namespace).Get(ctx, mkResource.Status.Binding.Name, metav1.GetOptions{})
+}
+
+// Age as shown by kubectl, e.g. 5d3h
+func age(t metav1.Time) string {
+	if t.IsZero() {
+		return "<unknown>"
+	}
+	return duration.HumanDuration(time.Since(t.Time))
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"strings"
+	"testing"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+	mkfake "mongokube/pkg/client/clientset/versioned/fake"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDescribe(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		plan        *beta1.PlanStatus
+		want        []string
+		notWant     []string
+	}{
+		{
+			name:    "unset fields are left out",
+			want:    []string{"Name:", "1 ready of 2", "mongo:7.0"},
+			notWant: []string{"Paused", "Maintenance", "Upgrade", "Planned changes", "Binding secret"},
+		},
+		{
+			name:        "paused by annotation",
+			annotations: map[string]string{beta1.PausedAnnotation: "true"},
+			want:        []string{"Paused:", "true"},
+			notWant:     []string{"Maintenance"},
+		},
+		{
+			name:    "empty plan",
+			plan:    &beta1.PlanStatus{},
+			notWant: []string{"Planned changes"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mkResource := &beta1.Mk{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", Annotations: tt.annotations},
+				Spec:       beta1.MkSpec{MongoDbImage: "mongo:7.0"},
+				Status:     beta1.MkStatus{Progress: "Running", ReadyReplicas: 1, Plan: tt.plan},
+			}
+			var out bytes.Buffer
+			p := &plugin{
+				namespace:  "default",
+				kubeClient: kubefake.NewSimpleClientset(),
+				mkClient:   mkfake.NewSimpleClientset(mkResource),
+				out:        &out,
+			}
+
+			run := describeCommand(flag.NewFlagSet("describe", flag.ContinueOnError))
+			if err := run(context.Background(), p, []string{"test"}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("output does not contain %q:\n%s", want, out.String())
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(out.String(), notWant) {
+					t.Errorf("output contains %q:\n%s", notWant, out.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+	"mongokube/pkg/connect"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Command running a MongoDB database tool in the MongoDB container, authenticated as the root
+// user. The tool connects to the replica set, so that it works against the primary. The tool
+// and its arguments are passed to the shell as $0 and $@.
+func mongoToolCommand(tool ...string) []string {
+	return append([]string{
+		"sh", "-c",
+		`exec "$0" "$@" --host "` + beta1.ReplicaSetName + `/localhost:27017" -u "$MONGO_INITDB_ROOT_USERNAME" -p "$MONGO_INITDB_ROOT_PASSWORD" --authenticationDatabase admin`,
+	}, tool...)
+}
+
+// A ready MongoDB pod of the Mk resource
+func (p *plugin) readyPod(ctx context.Context, mkResource *beta1.Mk) (string, error) {
+	if mkResource.Status.Selector == "" {
+		return "", fmt.Errorf("mk/%s has no pods yet, its status is %q", mkResource.Name, mkResource.Status.Progress)
+	}
+
+	podList, err := p.kubeClient.CoreV1().Pods(mkResource.Namespace).List(ctx, metav1.ListOptions{LabelSelector: mkResource.Status.Selector})
+	if err != nil {
+		return "", err
+	}
+	for i := range podList.Items {
+		if connect.IsPodReady(&podList.Items[i]) {
+			return podList.Items[i].Name, nil
+		}
+	}
+	return "", fmt.Errorf("mk/%s has no ready pod", mkResource.Name)
+}
+
+// Run a command in the MongoDB container of the pod, streaming stdin and stdout. Stderr is
+// returned with the error if the command fails.
+func (p *plugin) exec(ctx context.Context, mkResource *beta1.Mk, pod string, command []string, stdin io.Reader, stdout io.Writer) error {
+	return p.connector.Executor.Stream(ctx, mkResource.Namespace, pod, mkResource.MongoContainerName(), command, stdin, stdout)
+}
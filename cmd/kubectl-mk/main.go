@@ -0,0 +1,157 @@
+// kubectl-mk is a kubectl plugin for Mk resources, installed by putting it on the PATH and
+// run as `kubectl mk <command>`.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+
+	mkclientset "mongokube/pkg/client/clientset/versioned"
+	"mongokube/pkg/connect"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// command is a subcommand of the plugin. setup registers the flags of the command and returns
+// the function running it with the positional arguments.
+type command struct {
+	args  []string
+	usage string
+	setup func(flags *flag.FlagSet) func(ctx context.Context, p *plugin, args []string) error
+}
+
+var commands = map[string]command{
+	"list":        {nil, "List Mk resources with their status", listCommand},
+	"describe":    {[]string{"NAME"}, "Show the spec, status and events of a Mk resource", describeCommand},
+	"connect":     {[]string{"NAME"}, "Print the connection string, or forward a local port to the primary", connectCommand},
+	"shell":       {[]string{"NAME"}, "Run mongosh against the primary through a port-forward", shellCommand},
+	"credentials": {[]string{"NAME"}, "Print the credentials of the root user", credentialsCommand},
+	"backup now":  {[]string{"NAME"}, "Dump all databases to a local archive", backupCommand},
+	"restore":     {[]string{"NAME"}, "Restore a local archive written by backup now", restoreCommand},
+	"pause":       {[]string{"NAME"}, "Stop reconciling a Mk resource", pauseCommand(true)},
+	"resume":      {[]string{"NAME"}, "Resume reconciling a Mk resource", pauseCommand(false)},
+	"upgrade":     {[]string{"NAME"}, "Change the MongoDB image, the controller upgrades one member at a time", upgradeCommand},
+}
+
+// plugin holds the clients and settings shared by all commands
+type plugin struct {
+	namespace  string
+	kubeClient kubernetes.Interface
+	mkClient   mkclientset.Interface
+	connector  *connect.Client
+	out        io.Writer
+}
+
+func main() {
+	name, args := commandName(os.Args[1:])
+	cmd, ok := commands[name]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet("kubectl mk "+name, flag.ExitOnError)
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	overrides := &clientcmd.ConfigOverrides{}
+	flags.StringVar(&loadingRules.ExplicitPath, "kubeconfig", "", "Path to the kubeconfig file")
+	flags.StringVar(&overrides.CurrentContext, "context", "", "Name of the kubeconfig context to use")
+	flags.StringVar(&overrides.Context.Namespace, "namespace", "", "Namespace of the Mk resource, the one of the context if empty")
+	flags.StringVar(&overrides.Context.Namespace, "n", "", "Shorthand for --namespace")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: kubectl mk %s %s [flags]\n\n%s\n\nFlags:\n", name, strings.Join(cmd.args, " "), cmd.usage)
+		flags.PrintDefaults()
+	}
+	run := cmd.setup(flags)
+
+	positional := parseInterspersed(flags, args)
+	if len(positional) != len(cmd.args) {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	p, err := newPlugin(clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, p, positional); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+// Name of the command and its arguments, commands may consist of two words like backup now
+func commandName(args []string) (string, []string) {
+	if len(args) == 0 {
+		return "", nil
+	}
+	if len(args) > 1 {
+		if _, ok := commands[args[0]+" "+args[1]]; ok {
+			return args[0] + " " + args[1], args[2:]
+		}
+	}
+	return args[0], args[1:]
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: kubectl mk <command> [flags]\n\nCommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun kubectl mk <command> -h for the flags of a command.")
+}
+
+// Parse flags which may come before, between or after the positional arguments, as with kubectl
+func parseInterspersed(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		// the flag set exits on errors
+		_ = flags.Parse(args)
+		if flags.NArg() == 0 {
+			return positional
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+func newPlugin(clientConfig clientcmd.ClientConfig) (*plugin, error) {
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	namespace, _, err := clientConfig.Namespace()
+	if err != nil {
+		return nil, err
+	}
+
+	connector, err := connect.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &plugin{
+		namespace:  namespace,
+		kubeClient: connector.KubeClient,
+		mkClient:   connector.MkClient,
+		connector:  connector,
+		out:        os.Stdout,
+	}, nil
+}
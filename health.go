@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+var healthProbeBindAddress = flag.String("health-probe-bind-address", ":8081", "Address the /healthz and /readyz endpoints bind to, 0 disables them")
+
+// healthCheck returns an error if the operator is not healthy or not ready
+type healthCheck func(r *http.Request) error
+
+// Always succeeds, the process is alive as long as it answers
+func ping(_ *http.Request) error {
+	return nil
+}
+
+// Succeeds once the informer cache has been synched with the api server. Informers only run
+// while leading, replicas standing by are ready as well, so that rolling out the operator does
+// not wait for them to become the leader.
+func informerSyncCheck(leading *atomic.Bool, hasSynced func() bool) healthCheck {
+	return func(_ *http.Request) error {
+		if leading.Load() && !hasSynced() {
+			return errors.New("informer cache not synched")
+		}
+		return nil
+	}
+}
+
+// Handler serving /healthz and /readyz
+func healthHandler(liveness, readiness map[string]healthCheck) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", checksHandler(liveness))
+	mux.Handle("/readyz", checksHandler(readiness))
+	return mux
+}
+
+// Run all checks and answer 200 if they succeed, otherwise 500 with the failed checks.
+// With ?verbose the result of every check is listed.
+func checksHandler(checks map[string]healthCheck) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		names := make([]string, 0, len(checks))
+		for name := range checks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var out strings.Builder
+		failed := false
+		for _, name := range names {
+			if err := checks[name](r); err != nil {
+				failed = true
+				fmt.Fprintf(&out, "[-] %s failed: %s\n", name, err.Error())
+			} else {
+				fmt.Fprintf(&out, "[+] %s ok\n", name)
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if failed {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, out.String())
+			return
+		}
+
+		if _, verbose := r.URL.Query()["verbose"]; verbose {
+			fmt.Fprint(w, out.String())
+		}
+		fmt.Fprint(w, "ok")
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestInformerSyncCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		leading bool
+		synced  bool
+		wantErr bool
+	}{
+		{name: "standing by", leading: false, synced: false},
+		{name: "leader synching", leading: true, synced: false, wantErr: true},
+		{name: "leader synched", leading: true, synced: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var leading atomic.Bool
+			leading.Store(tt.leading)
+			check := informerSyncCheck(&leading, func() bool { return tt.synced })
+
+			if err := check(httptest.NewRequest("GET", "/readyz", nil)); (err != nil) != tt.wantErr {
+				t.Errorf("check() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestChecksHandler(t *testing.T) {
+	var leading atomic.Bool
+	leading.Store(true)
+	handler := healthHandler(map[string]healthCheck{"ping": ping}, map[string]healthCheck{
+		"informer-sync": informerSyncCheck(&leading, func() bool { return false }),
+	})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz?verbose", nil))
+	if rec.Code != 200 || rec.Body.String() != "[+] ping ok\nok" {
+		t.Errorf("/healthz = %d %q, want 200 with the ping check", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != 500 || rec.Body.String() != "[-] informer-sync failed: informer cache not synched\n" {
+		t.Errorf("/readyz = %d %q, want 500 with the failed check", rec.Code, rec.Body.String())
+	}
+}
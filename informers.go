@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	mkclientset "mongokube/pkg/client/clientset/versioned"
+	mkinformers "mongokube/pkg/client/informers/externalversions"
+	mkbeta1informers "mongokube/pkg/client/informers/externalversions/mongokube/beta1"
+
+	"mongokube/pkg/controller"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/metadata/metadatainformer"
+)
+
+var (
+	namespaces = flag.String("namespaces", "", "Comma separated list of namespaces to watch, all namespaces if empty")
+	mkSelector = flag.String("mk-selector", "", "Only manage Mk resources matching this label selector, e.g. team=payments")
+)
+
+const resyncPeriod = 10 * time.Minute
+
+// informers holds one informer factory per watched namespace for Mk resources, for owned objects
+// and for the metadata of objects referenced by Mk resources
+type informers struct {
+	mkFactories       []mkinformers.SharedInformerFactory
+	kubeFactories     []kubeinformers.SharedInformerFactory
+	metadataFactories []metadatainformer.SharedInformerFactory
+	mkInformers       map[string]mkbeta1informers.MkInformer
+}
+
+// Namespaces given by --namespaces, a single empty namespace stands for all namespaces
+func watchedNamespaces() []string {
+	var watched []string
+	for _, namespace := range strings.Split(*namespaces, ",") {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			watched = append(watched, namespace)
+		}
+	}
+	if len(watched) == 0 {
+		return []string{metav1.NamespaceAll}
+	}
+	return watched
+}
+
+func newInformers(k8sclient kubernetes.Interface, mkclient mkclientset.Interface, metadataClient metadata.Interface) (*informers, error) {
+	if _, err := labels.Parse(*mkSelector); err != nil {
+		return nil, fmt.Errorf("invalid --mk-selector: %w", err)
+	}
+
+	selectMks := func(options *metav1.ListOptions) {
+		options.LabelSelector = *mkSelector
+	}
+
+	i := &informers{mkInformers: map[string]mkbeta1informers.MkInformer{}}
+	for _, namespace := range watchedNamespaces() {
+		mkFactory := mkinformers.NewSharedInformerFactoryWithOptions(mkclient, resyncPeriod,
+			mkinformers.WithNamespace(namespace), mkinformers.WithTweakListOptions(selectMks))
+
+		// Only objects created by the controller are cached
+		kubeFactory := kubeinformers.NewSharedInformerFactoryWithOptions(k8sclient, resyncPeriod,
+			kubeinformers.WithNamespace(namespace), kubeinformers.WithTweakListOptions(controller.SelectOwnedObjects))
+
+		// Referenced objects are created by the user, so they carry no label to select them by
+		metadataFactory := metadatainformer.NewFilteredSharedInformerFactory(metadataClient, resyncPeriod, namespace, nil)
+
+		i.mkFactories = append(i.mkFactories, mkFactory)
+		i.kubeFactories = append(i.kubeFactories, kubeFactory)
+		i.metadataFactories = append(i.metadataFactories, metadataFactory)
+		i.mkInformers[namespace] = mkFactory.Mongokube().Beta1().Mks()
+	}
+
+	return i, nil
+}
+
+// Start the informers requested so far, they run until stopCh is closed
+func (i *informers) Start(stopCh <-chan struct{}) {
+	for _, factory := range i.mkFactories {
+		factory.Start(stopCh)
+	}
+	for _, factory := range i.kubeFactories {
+		factory.Start(stopCh)
+	}
+	for _, factory := range i.metadataFactories {
+		factory.Start(stopCh)
+	}
+}
+
+// Shutdown blocks until the informer goroutines have terminated
+func (i *informers) Shutdown() {
+	for _, factory := range i.mkFactories {
+		factory.Shutdown()
+	}
+	for _, factory := range i.kubeFactories {
+		factory.Shutdown()
+	}
+	for _, factory := range i.metadataFactories {
+		factory.Shutdown()
+	}
+}
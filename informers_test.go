@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+	mkfake "mongokube/pkg/client/clientset/versioned/fake"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Set a flag for the duration of the test
+func setFlag(t *testing.T, flag *string, value string) {
+	previous := *flag
+	*flag = value
+	t.Cleanup(func() { *flag = previous })
+}
+
+func TestWatchedNamespaces(t *testing.T) {
+	tests := []struct {
+		name       string
+		namespaces string
+		want       []string
+	}{
+		{name: "all namespaces", want: []string{""}},
+		{name: "only separators", namespaces: " , ", want: []string{""}},
+		{name: "single namespace", namespaces: "payments", want: []string{"payments"}},
+		{name: "spaces and empty entries", namespaces: "payments, search,,", want: []string{"payments", "search"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, namespaces, tt.namespaces)
+
+			got := watchedNamespaces()
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") || len(got) != len(tt.want) {
+				t.Errorf("watchedNamespaces() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewInformers(t *testing.T) {
+	mk := func(namespace, name, team string) *beta1.Mk {
+		return &beta1.Mk{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: map[string]string{"team": team}}}
+	}
+	mkclient := mkfake.NewSimpleClientset(
+		mk("a", "payments", "payments"),
+		mk("a", "search", "search"),
+		mk("b", "payments", "payments"),
+		mk("c", "payments", "payments"),
+	)
+
+	tests := []struct {
+		name       string
+		namespaces string
+		selector   string
+		want       map[string][]string
+		wantErr    string
+	}{
+		{
+			name:     "invalid selector",
+			selector: "team in (",
+			wantErr:  "invalid --mk-selector",
+		},
+		{
+			name: "all namespaces",
+			want: map[string][]string{"": {"a/payments", "a/search", "b/payments", "c/payments"}},
+		},
+		{
+			name:     "selected in all namespaces",
+			selector: "team=payments",
+			want:     map[string][]string{"": {"a/payments", "b/payments", "c/payments"}},
+		},
+		{
+			name:       "selected in watched namespaces",
+			namespaces: "a,b",
+			selector:   "team=payments",
+			want:       map[string][]string{"a": {"a/payments"}, "b": {"b/payments"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, namespaces, tt.namespaces)
+			setFlag(t, mkSelector, tt.selector)
+
+			i, err := newInformers(kubefake.NewSimpleClientset(), mkclient, nil)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(i.mkInformers) != len(tt.want) {
+				t.Fatalf("%d Mk informers, want %d", len(i.mkInformers), len(tt.want))
+			}
+
+			// informers are only started once they are requested
+			var synced []cache.InformerSynced
+			for _, informer := range i.mkInformers {
+				synced = append(synced, informer.Informer().HasSynced)
+			}
+			stopCh := make(chan struct{})
+			i.Start(stopCh)
+			defer i.Shutdown()
+			defer close(stopCh)
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+				t.Fatal("informers did not sync")
+			}
+
+			for namespace, want := range tt.want {
+				informer, ok := i.mkInformers[namespace]
+				if !ok {
+					t.Fatalf("no Mk informer for namespace %q", namespace)
+				}
+				mks, err := informer.Lister().List(labels.Everything())
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got []string
+				for _, mk := range mks {
+					got = append(got, mk.Namespace+"/"+mk.Name)
+				}
+				sort.Strings(got)
+				if strings.Join(got, ",") != strings.Join(want, ",") {
+					t.Errorf("informer for %q holds %v, want %v", namespace, got, want)
+				}
+			}
+		})
+	}
+}
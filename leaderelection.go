@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+)
+
+// Namespace of the pod, mounted together with the service account token
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+var (
+	leaderElect              = flag.Bool("leader-elect", false, "Elect a leader through a Lease before starting the workers, required when running more than one replica of the operator")
+	leaderElectLeaseName     = flag.String("leader-elect-lease-name", "mongokube-leader", "Name of the Lease object used for leader election")
+	leaderElectNamespace     = flag.String("leader-elect-namespace", "", "Namespace of the Lease object, defaults to the namespace the operator runs in")
+	leaderElectIdentity      = flag.String("leader-elect-identity", "", "Identity of this replica in the Lease, defaults to the hostname with a random suffix")
+	leaderElectLeaseDuration = flag.Duration("leader-elect-lease-duration", 15*time.Second, "Duration non-leaders wait before trying to take over an unrenewed Lease")
+	leaderElectRenewDeadline = flag.Duration("leader-elect-renew-deadline", 10*time.Second, "Duration the leader retries renewing the Lease before giving up leadership")
+	leaderElectRetryPeriod   = flag.Duration("leader-elect-retry-period", 2*time.Second, "Duration between attempts to acquire or renew the Lease")
+)
+
+// Create a leader elector which calls run once this replica has become the leader. The context
+// passed to run is cancelled when leadership is lost, afterwards the process exits so that it
+// can start over with empty caches and a new workqueue. The elector has to be run with the
+// returned context, which is cancelled once ctx is cancelled and run has returned, so that the
+// Lease is only released after the workers have stopped.
+func newLeaderElector(ctx context.Context, k8sclient kubernetes.Interface, watchdog *leaderelection.HealthzAdaptor, run func(ctx context.Context)) (context.Context, *leaderelection.LeaderElector, error) {
+	logger := klog.FromContext(ctx)
+	identity := *leaderElectIdentity
+	if identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, nil, err
+		}
+		identity = hostname + "_" + string(uuid.NewUUID())
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      *leaderElectLeaseName,
+			Namespace: leaderElectionNamespace(),
+		},
+		Client: k8sclient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+
+	// closed once run has started and returned, so that the workers are stopped before exiting
+	started := make(chan struct{})
+	stopped := make(chan struct{})
+
+	// Renewing the Lease outlives ctx until run has returned, a replica which is not the
+	// leader stops trying to become one right away
+	electionCtx, cancelElection := context.WithCancel(context.WithoutCancel(ctx))
+	go func() {
+		<-ctx.Done()
+		select {
+		case <-started:
+		default:
+			cancelElection()
+		}
+	}()
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   *leaderElectLeaseDuration,
+		RenewDeadline:   *leaderElectRenewDeadline,
+		RetryPeriod:     *leaderElectRetryPeriod,
+		ReleaseOnCancel: true,
+		WatchDog:        watchdog,
+		Name:            "mongokube",
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				logger.Info("Became leader, starting workers", "identity", identity)
+				close(started)
+				defer close(stopped)
+				run(ctx)
+				cancelElection()
+			},
+			OnStoppedLeading: func() {
+				select {
+				case <-started:
+					<-stopped
+				default:
+				}
+				if ctx.Err() != nil {
+					logger.Info("Released leadership", "identity", identity)
+					return
+				}
+				logger.Info("Lost leadership, exiting", "identity", identity)
+				os.Exit(1)
+			},
+			OnNewLeader: func(current string) {
+				if current == identity {
+					return
+				}
+				logger.Info("New leader elected", "leader", current)
+			},
+		},
+	})
+	if err != nil {
+		cancelElection()
+		return nil, nil, err
+	}
+	return electionCtx, elector, nil
+}
+
+// Namespace of the Lease, the namespace of the operator pod if not set by flag
+func leaderElectionNamespace() string {
+	if *leaderElectNamespace != "" {
+		return *leaderElectNamespace
+	}
+
+	if namespace, err := os.ReadFile(serviceAccountNamespaceFile); err == nil {
+		return strings.TrimSpace(string(namespace))
+	}
+
+	return "default"
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+
+	"github.com/go-logr/logr/slogr"
+	"k8s.io/klog/v2"
+)
+
+var logFormat = flag.String("log-format", "text", "Log output format, text or json")
+
+func init() {
+	// registers -v, which sets the verbosity of the operator and of client-go
+	klog.InitFlags(nil)
+}
+
+// Route all logs, including those of client-go, through a structured logger writing
+// to stderr in the format chosen by --log-format. Messages logged with V(n) are only
+// written if n is not above the verbosity set by -v.
+func setupLogging() (klog.Logger, error) {
+	verbosity, err := strconv.Atoi(flag.Lookup("v").Value.String())
+	if err != nil {
+		return klog.Logger{}, err
+	}
+
+	// V(n) of logr is mapped to slog level -n
+	options := &slog.HandlerOptions{Level: slog.Level(-verbosity)}
+
+	var handler slog.Handler
+	switch *logFormat {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, options)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	default:
+		return klog.Logger{}, fmt.Errorf("unknown log format %q, must be text or json", *logFormat)
+	}
+
+	logger := slogr.NewLogr(handler)
+	klog.SetLogger(logger)
+
+	return logger, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"k8s.io/klog/v2"
+)
+
+// Set a flag of the command line as it is parsed, for the duration of the test
+func setCommandLineFlag(t *testing.T, name, value string) {
+	previous := flag.Lookup(name).Value.String()
+	if err := flag.Set(name, value); err != nil {
+		t.Fatalf("failed to set -%s: %v", name, err)
+	}
+	t.Cleanup(func() { flag.Set(name, previous) })
+}
+
+// Run log with stderr redirected and return what was written to it
+func captureStderr(t *testing.T, log func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = stderr }()
+
+	log()
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestSetupLogging(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		wantJSON  bool
+		wantDebug bool
+		wantErr   string
+	}{
+		{name: "defaults"},
+		{name: "text", args: []string{"--log-format=text"}},
+		{name: "json", args: []string{"--log-format", "json"}, wantJSON: true},
+		{name: "verbose", args: []string{"-v=2"}, wantDebug: true},
+		{name: "unknown format", args: []string{"--log-format=yaml"}, wantErr: `unknown log format "yaml"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setCommandLineFlag(t, "log-format", "text")
+			setCommandLineFlag(t, "v", "0")
+			t.Cleanup(klog.ClearLogger)
+			if err := flag.CommandLine.Parse(tt.args); err != nil {
+				t.Fatalf("failed to parse %v: %v", tt.args, err)
+			}
+
+			var logger klog.Logger
+			out := captureStderr(t, func() {
+				var err error
+				logger, err = setupLogging()
+				if tt.wantErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+						t.Errorf("error = %v, want one containing %q", err, tt.wantErr)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				logger.Info("Starting", "workers", 2)
+				logger.V(2).Info("Reconciling")
+			})
+			if tt.wantErr != "" {
+				return
+			}
+
+			var entry map[string]interface{}
+			firstLine, _, _ := strings.Cut(out, "\n")
+			if isJSON := json.Unmarshal([]byte(firstLine), &entry) == nil; isJSON != tt.wantJSON {
+				t.Errorf("logged %q, want JSON %v", firstLine, tt.wantJSON)
+			}
+			if !strings.Contains(firstLine, "Starting") || !strings.Contains(firstLine, "workers") {
+				t.Errorf("logged %q, want the message and its key/value pairs", firstLine)
+			}
+			if strings.Contains(out, "Reconciling") != tt.wantDebug {
+				t.Errorf("logged %q, want V(2) messages %v", out, tt.wantDebug)
+			}
+		})
+	}
+}
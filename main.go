@@ -1,66 +1,199 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"os"
+	"os/signal"
 	"path/filepath"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	mkclientset "mongokube/pkg/client/clientset/versioned"
-	mkinformers "mongokube/pkg/client/informers/externalversions"
 
 	"mongokube/pkg/controller"
+	"mongokube/pkg/metrics"
 
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
 	"k8s.io/client-go/util/homedir"
+	"k8s.io/klog/v2"
+)
+
+var (
+	shutdownTimeout = flag.Duration("shutdown-timeout", 30*time.Second, "Time to wait for in-flight reconciles to finish on shutdown")
+	workers         = flag.Int("workers", 1, "Number of Mk resources reconciled in parallel")
+	baseBackoff     = flag.Duration("base-backoff", 5*time.Millisecond, "Initial delay before retrying a failed reconcile, doubled on every failure")
+	maxBackoff      = flag.Duration("max-backoff", 1000*time.Second, "Maximum delay before retrying a failed reconcile")
+	queueQPS        = flag.Float64("queue-qps", 10, "Overall rate at which failed reconciles are retried")
+	queueBurst      = flag.Int("queue-burst", 100, "Burst of retries allowed above --queue-qps")
+	kubeAPIQPS      = flag.Float64("kube-api-qps", 20, "Queries per second to the Kubernetes API server")
+	kubeAPIBurst    = flag.Int("kube-api-burst", 30, "Burst of queries allowed above --kube-api-qps")
+	imageRegistry   = flag.String("image-registry", "", "Pull all images from this registry instead of the one in their name, e.g. a mirror in air-gapped clusters")
+	dryRun          = flag.Bool("dry-run", false, "Only plan the changes to every Mk resource as server-side dry-run and report them in its status")
 )
 
 func main() {
-	config := getConfig()
+	if len(os.Args) > 1 && os.Args[1] == "render" {
+		if err := renderCommand(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	flag.Parse()
+
+	options := controller.Options{
+		Workers:         *workers,
+		BaseBackoff:     *baseBackoff,
+		MaxBackoff:      *maxBackoff,
+		QueueQPS:        *queueQPS,
+		QueueBurst:      *queueBurst,
+		ShutdownTimeout: *shutdownTimeout,
+		ImageRegistry:   *imageRegistry,
+		DryRun:          *dryRun,
+	}
+	if err := validateOptions(options); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+
+	logger, err := setupLogging()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	config := getConfig(logger)
+	config.QPS = float32(*kubeAPIQPS)
+	config.Burst = *kubeAPIBurst
 
 	k8sclient, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		fmt.Printf("Error getting k8sclient, %s", err.Error())
+		logger.Error(err, "Error getting k8sclient")
+		os.Exit(1)
 	}
 
 	mkclient, err := mkclientset.NewForConfig(config)
 	if err != nil {
-		fmt.Printf("Error getting mkclient, %s", err.Error())
+		logger.Error(err, "Error getting mkclient")
+		os.Exit(1)
+	}
+
+	metadataClient, err := metadata.NewForConfig(config)
+	if err != nil {
+		logger.Error(err, "Error getting metadata client")
+		os.Exit(1)
+	}
+
+	informers, err := newInformers(k8sclient, mkclient, metadataClient)
+	if err != nil {
+		logger.Error(err, "Error setting up informers")
+		os.Exit(1)
 	}
 
-	mkinformers := mkinformers.NewSharedInformerFactory(mkclient, 10*time.Minute)
+	c := controller.NewController(k8sclient, mkclient, informers.mkInformers, informers.kubeFactories, informers.metadataFactories, config, options)
 
-	c := controller.NewController(*k8sclient, mkclient, mkinformers.Mongokube().Beta1().Mks())
+	// Cancelled on SIGINT or SIGTERM, e.g. when the pod is deleted
+	ctx, stop := signal.NotifyContext(klog.NewContext(context.Background(), logger), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	channel := make(chan struct{})
+	metrics.Registry.MustRegister(metrics.NewMkCollector(c.Lister()))
+	serve(ctx, "metrics", *metricsBindAddress, metricsHandler())
 
-	mkinformers.Start(channel)
+	// Informers and workers only run on the leader. They stop taking new work once ctx or
+	// leaderCtx is cancelled, reconciles in flight are finished unless leaderCtx is cancelled,
+	// as another replica may already be the leader then.
+	var leading atomic.Bool
+	run := func(leaderCtx context.Context) {
+		leading.Store(true)
+		metrics.SetLeader(true)
+		defer func() {
+			leading.Store(false)
+			metrics.SetLeader(false)
+		}()
 
-	c.Run(channel)
+		stopCtx, cancel := context.WithCancel(leaderCtx)
+		defer cancel()
+		stopCancelling := context.AfterFunc(ctx, cancel)
+		defer stopCancelling()
+
+		informers.Start(stopCtx.Done())
+
+		c.Run(stopCtx, leaderCtx)
+
+		// Blocks until the informer goroutines have terminated
+		informers.Shutdown()
+	}
+	// The leader is ready once the informer cache has been synched, the other replicas right away
+	livenessChecks := map[string]healthCheck{"ping": ping}
+	readinessChecks := map[string]healthCheck{"informer-sync": informerSyncCheck(&leading, c.HasSynced)}
+
+	if !*leaderElect {
+		serve(ctx, "health probes", *healthProbeBindAddress, healthHandler(livenessChecks, readinessChecks))
+		run(context.WithoutCancel(ctx))
+		return
+	}
+
+	// Fails liveness if the leader could not renew the Lease in time, but keeps running
+	watchdog := leaderelection.NewLeaderHealthzAdaptor(20 * time.Second)
+	electionCtx, elector, err := newLeaderElector(ctx, k8sclient, watchdog, run)
+	if err != nil {
+		logger.Error(err, "Error setting up leader election")
+		os.Exit(1)
+	}
+
+	livenessChecks["leader-election"] = watchdog.Check
+	serve(ctx, "health probes", *healthProbeBindAddress, healthHandler(livenessChecks, readinessChecks))
+
+	// The elector only stops renewing and releases the Lease once run has returned, so that the
+	// next leader never overlaps with reconciles which are still being finished
+	elector.Run(electionCtx)
 }
 
-func getConfig() *rest.Config {
-	// This function set the configuration for kubernetes
-	var kubeconfigpath *string
+// Reject flag values the workqueue cannot run with: no workers never reconcile anything, and
+// the retry rate limiter never lets a retry through without a positive rate and burst
+func validateOptions(options controller.Options) error {
+	if options.Workers < 1 {
+		return fmt.Errorf("--workers must be at least 1, got %d", options.Workers)
+	}
+	if options.QueueQPS <= 0 {
+		return fmt.Errorf("--queue-qps must be positive, got %v", options.QueueQPS)
+	}
+	if options.QueueBurst < 1 {
+		return fmt.Errorf("--queue-burst must be at least 1, got %d", options.QueueBurst)
+	}
+	return nil
+}
 
+// Defined when the program starts, so that all flags are known before flag.Parse
+var kubeconfigpath = kubeconfigFlag()
+
+func kubeconfigFlag() *string {
 	// create filepath of kube config file which is at /home/apmec/.kube/config
 	if home := homedir.HomeDir(); home != "" {
-		kubeconfigpath = flag.String("kubeconfig", filepath.Join(home, ".kube", "config"), "(optional) absolute path to the kubeconfig file")
-	} else {
-		kubeconfigpath = flag.String("kubeconfig", "", "absolute path to the kubeconfig file")
+		return flag.String("kubeconfig", filepath.Join(home, ".kube", "config"), "(optional) absolute path to the kubeconfig file")
 	}
-	flag.Parse()
+	return flag.String("kubeconfig", "", "absolute path to the kubeconfig file")
+}
 
+func getConfig(logger klog.Logger) *rest.Config {
+	// This function set the configuration for kubernetes
 	// creates configuration based on config path
 	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfigpath)
 	if err != nil {
-		fmt.Printf("Could not get the config file due to %s", err.Error())
+		logger.Info("Could not get the config file, trying in-cluster config", "err", err)
 
 		config, err = rest.InClusterConfig()
 		if err != nil {
-			fmt.Printf("Error %s, getting incluster config", err.Error())
+			logger.Error(err, "Error getting in-cluster config")
+			os.Exit(1)
 		}
 	}
 	return config
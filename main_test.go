@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"mongokube/pkg/controller"
+)
+
+func TestValidateOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(options *controller.Options)
+		wantErr string
+	}{
+		{name: "defaults", mutate: func(*controller.Options) {}},
+		{name: "no workers", mutate: func(options *controller.Options) { options.Workers = 0 }, wantErr: "--workers"},
+		{name: "zero retry rate", mutate: func(options *controller.Options) { options.QueueQPS = 0 }, wantErr: "--queue-qps"},
+		{name: "negative retry rate", mutate: func(options *controller.Options) { options.QueueQPS = -1 }, wantErr: "--queue-qps"},
+		{name: "zero burst", mutate: func(options *controller.Options) { options.QueueBurst = 0 }, wantErr: "--queue-burst"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := controller.Options{Workers: 1, QueueQPS: 10, QueueBurst: 100}
+			tt.mutate(&options)
+
+			err := validateOptions(options)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
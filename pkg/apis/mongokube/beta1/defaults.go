@@ -0,0 +1,41 @@
+package beta1
+
+const (
+	// Default number of replica set members if spec.replicas is not set
+	DefaultReplicas int32 = 2
+
+	// Name of the replica set run by the controller
+	ReplicaSetName = "rs0"
+
+	// Annotations which do the same as spec.paused and spec.maintenance when set to "true"
+	PausedAnnotation      = "mongokube.wrd/paused"
+	MaintenanceAnnotation = "mongokube.wrd/maintenance"
+)
+
+// Replicas returns the number of replica set members requested by the user
+func (mk *Mk) Replicas() int32 {
+	if mk.Spec.Replicas == nil {
+		return DefaultReplicas
+	}
+	return *mk.Spec.Replicas
+}
+
+// Paused reports whether reconciling is paused through spec.paused or the paused annotation
+func (mk *Mk) Paused() bool {
+	return mk.Spec.Paused || mk.Annotations[PausedAnnotation] == "true"
+}
+
+// InMaintenance reports whether the instance is under maintenance through spec.maintenance or
+// the maintenance annotation
+func (mk *Mk) InMaintenance() bool {
+	return mk.Spec.Maintenance || mk.Annotations[MaintenanceAnnotation] == "true"
+}
+
+// MongoContainerName returns the name of the MongoDB container in the pods, the one of
+// spec.adopt if it is set
+func (mk *Mk) MongoContainerName() string {
+	if mk.Spec.Adopt != nil && mk.Spec.Adopt.Container != "" {
+		return mk.Spec.Adopt.Container
+	}
+	return mk.Name + "-container"
+}
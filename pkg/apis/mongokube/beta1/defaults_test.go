@@ -0,0 +1,24 @@
+package beta1
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMkDefaults(t *testing.T) {
+	mk := &Mk{ObjectMeta: metav1.ObjectMeta{Name: "test"}}
+	if mk.Replicas() != DefaultReplicas || mk.Paused() || mk.InMaintenance() || mk.MongoContainerName() != "test-container" {
+		t.Errorf("defaults = %d replicas, paused %v, maintenance %v, container %s",
+			mk.Replicas(), mk.Paused(), mk.InMaintenance(), mk.MongoContainerName())
+	}
+
+	replicas := int32(3)
+	mk.Spec.Replicas = &replicas
+	mk.Annotations = map[string]string{PausedAnnotation: "true", MaintenanceAnnotation: "false"}
+	mk.Spec.Adopt = &AdoptSpec{StatefulSet: "mongo", Container: "mongod"}
+	if mk.Replicas() != 3 || !mk.Paused() || mk.InMaintenance() || mk.MongoContainerName() != "mongod" {
+		t.Errorf("got %d replicas, paused %v, maintenance %v, container %s, want 3, true, false, mongod",
+			mk.Replicas(), mk.Paused(), mk.InMaintenance(), mk.MongoContainerName())
+	}
+}
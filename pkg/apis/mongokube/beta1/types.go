@@ -1,7 +1,10 @@
 package beta1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // +genclient
@@ -20,10 +23,175 @@ type MkSpec struct {
 	MongoDbImage            string `json:"mongoDbImage"`
 	DbUsername              string `json:"dbUsername"`
 	DbPassword              string `json:"dbPassword"`
+	// Number of members of the MongoDB replica set, exposed through the scale subresource
+	Replicas *int32 `json:"replicas,omitempty"`
+	// Stop reconciling the instance, only its status is still reported
+	Paused bool `json:"paused,omitempty"`
+	// Scale Mongo Express to zero and leave the replica set members alone for manual maintenance
+	Maintenance bool `json:"maintenance,omitempty"`
+	// Limits how many MongoDB pods may be evicted at once, e.g. by node drains
+	PodDisruptionBudget *PodDisruptionBudgetSpec `json:"podDisruptionBudget,omitempty"`
+	// Restrict access to MongoDB to the listed clients, Mongo Express and the replica set members
+	NetworkPolicy *NetworkPolicySpec `json:"networkPolicy,omitempty"`
+	// Export metrics of the MongoDB pods for Prometheus
+	Monitoring *MonitoringSpec `json:"monitoring,omitempty"`
+	// mongod.conf of the MongoDB pods, options set by the operator itself may not be changed
+	MongodConfig *MongodConfigSpec `json:"mongodConfig,omitempty"`
+	// ConfigMaps and Secrets with .js and .sh files run when the instance is created
+	InitScripts []InitScriptSource `json:"initScripts,omitempty"`
+	// Secrets with credentials for pulling the images from private registries
+	ImagePullSecrets []LocalObjectReference `json:"imagePullSecrets,omitempty"`
+	// Pull policy of all containers, Always, IfNotPresent or Never
+	ImagePullPolicy string `json:"imagePullPolicy,omitempty"`
+	// Customizes mongodb-service and adds services for access from outside the cluster
+	Service *ServiceSpec `json:"service,omitempty"`
+	// Takes over an existing MongoDB statefulset instead of creating a new one
+	Adopt *AdoptSpec `json:"adopt,omitempty"`
+	// Persistent volume claimed by every MongoDB member for its data
+	Storage *StorageSpec `json:"storage,omitempty"`
+	// Compute resources of the MongoDB container, requests default to 100m CPU and 256Mi memory
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+type StorageSpec struct {
+	// Size of the volume of every member, defaults to 1Gi. Only used when the statefulset is created.
+	Size *resource.Quantity `json:"size,omitempty"`
+	// Storage class of the volumes, the default storage class of the cluster if unset
+	StorageClassName *string `json:"storageClassName,omitempty"`
+}
+
+type PodDisruptionBudgetSpec struct {
+	// Number or percentage of MongoDB pods which may be unavailable, by default as many as
+	// possible without losing the majority of the replica set, and 1 of 2 members
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+}
+
+type NetworkPolicySpec struct {
+	// Clients which may connect to MongoDB, nobody else if empty
+	AllowedClients []NetworkPolicyPeer `json:"allowedClients,omitempty"`
+}
+
+// Pods selected like in a NetworkPolicy, a pod selector alone selects pods in the namespace of
+// the Mk resource, a namespace selector alone all pods in the selected namespaces
+type NetworkPolicyPeer struct {
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	PodSelector       *metav1.LabelSelector `json:"podSelector,omitempty"`
+}
+
+type MonitoringSpec struct {
+	// Image of the mongodb_exporter sidecar, defaults to percona/mongodb_exporter
+	ExporterImage string `json:"exporterImage,omitempty"`
+	// Create a ServiceMonitor for the Prometheus operator, if its CRD is installed
+	ServiceMonitor bool `json:"serviceMonitor,omitempty"`
+}
+
+type MongodConfigSpec struct {
+	// mongod.conf in YAML
+	Inline string `json:"inline,omitempty"`
+	// ConfigMap holding mongod.conf, used instead of inline
+	ConfigMapRef *ConfigMapKeyReference `json:"configMapRef,omitempty"`
+}
+
+type ConfigMapKeyReference struct {
+	Name string `json:"name"`
+	// Key holding the file, defaults to mongod.conf
+	Key string `json:"key,omitempty"`
+}
+
+// Either a ConfigMap or a Secret in the namespace of the Mk resource
+type InitScriptSource struct {
+	ConfigMap *LocalObjectReference `json:"configMap,omitempty"`
+	Secret    *LocalObjectReference `json:"secret,omitempty"`
+}
+
+type LocalObjectReference struct {
+	Name string `json:"name"`
+}
+
+// Either a StatefulSet with its headless service, which is taken over, or a Deployment, whose
+// databases are copied into a new replica set
+type AdoptSpec struct {
+	// StatefulSet running MongoDB, its pods and volumes are kept
+	StatefulSet string `json:"statefulSet,omitempty"`
+	// Headless service of the statefulset, spec.serviceName of the statefulset
+	Service string `json:"service,omitempty"`
+	// Deployment running MongoDB, e.g. with its data on a PersistentVolumeClaim. The databases
+	// of its pods are copied into the replica set, then it is deleted; its claims are kept.
+	Deployment string `json:"deployment,omitempty"`
+	// Secret with the credentials of the root user in the keys username and password,
+	// which have to match dbUsername and dbPassword. mongodb-secret is used if empty.
+	Secret string `json:"secret,omitempty"`
+	// MongoDB container in the pod template of the statefulset, <name>-container if empty.
+	// Not used with deployment.
+	Container string `json:"container,omitempty"`
+}
+
+type ServiceSpec struct {
+	// ClusterIP (default), NodePort or LoadBalancer
+	Type string `json:"type,omitempty"`
+	// Port of the service, defaults to 27017
+	Port int32 `json:"port,omitempty"`
+	// Annotations of the service, e.g. for cloud load balancers
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Client IP ranges allowed to reach a LoadBalancer service
+	LoadBalancerSourceRanges []string `json:"loadBalancerSourceRanges,omitempty"`
+	// Cluster or Local, for NodePort and LoadBalancer services
+	ExternalTrafficPolicy string `json:"externalTrafficPolicy,omitempty"`
+	// One service per replica set member, so that clients outside the cluster can reach every member
+	External *ExternalAccessSpec `json:"external,omitempty"`
+}
+
+type ExternalAccessSpec struct {
+	// NodePort or LoadBalancer (default)
+	Type string `json:"type,omitempty"`
+	// Annotations of the per member services
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Client IP ranges allowed to reach LoadBalancer services
+	LoadBalancerSourceRanges []string `json:"loadBalancerSourceRanges,omitempty"`
+	// Domain under which member <pod> is reachable as <pod>.<domain>, added to the replica set
+	// config as the horizon "external" for split-horizon DNS. Requires tls.
+	Domain string `json:"domain,omitempty"`
+	// Certificate the members serve to clients which connect with TLS. MongoDB picks the horizon
+	// by the server name of the TLS handshake, so it is required with domain.
+	TLS *ExternalTLSSpec `json:"tls,omitempty"`
+}
+
+// TLS certificate of the members, clients may still connect without TLS
+type ExternalTLSSpec struct {
+	// Secret of type kubernetes.io/tls with a certificate for <pod>.<domain> of every member, e.g.
+	// for *.<domain>. Its ca.crt, if any, verifies client certificates, which are optional.
+	SecretName string `json:"secretName"`
 }
 
 type MkStatus struct {
 	Progress string `json:"progress"`
+	// Number of MongoDB pods currently running, read by the scale subresource
+	Replicas int32 `json:"replicas"`
+	// Number of MongoDB pods which are ready
+	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
+	// Label selector of the MongoDB pods in string form, used by HorizontalPodAutoscaler
+	Selector string `json:"selector,omitempty"`
+	// Image run by all MongoDB members, changed once an upgrade has finished
+	MongoDbImage string `json:"mongoDbImage,omitempty"`
+	// Progress of a MongoDB version upgrade or why it is blocked, empty if there is none
+	Upgrade string `json:"upgrade,omitempty"`
+	// Secret with the connection details, as defined for provisioned services by the Service Binding specification
+	Binding *BindingStatus `json:"binding,omitempty"`
+	// Whether the init scripts ran when the instance was created, Completed or NotRun
+	Initialization string `json:"initialization,omitempty"`
+	// Changes the last dry-run reconcile would have made, only set while dry-run is enabled
+	Plan *PlanStatus `json:"plan,omitempty"`
+	// Latest observations of the instance, e.g. RolloutBlocked when members cannot be restarted safely
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+type BindingStatus struct {
+	Name string `json:"name"`
+}
+
+type PlanStatus struct {
+	// e.g. "create Secret mongodb-secret" or "update StatefulSet mongokube-test-mongodb"
+	Changes []string `json:"changes"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
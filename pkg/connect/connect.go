@@ -0,0 +1,135 @@
+// Package connect connects clients outside the cluster to the MongoDB replica set of a Mk
+// resource: it finds the primary, forwards a local port to it and runs mongosh against it.
+package connect
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+	mkclientset "mongokube/pkg/client/clientset/versioned"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// Port MongoDB listens on in the pods
+const MongoDbPort = 27017
+
+// Asks a member for the primary of the replica set, which needs no authentication
+const primaryScript = "print(db.adminCommand({isMaster: 1}).primary)"
+
+// Dialer opens the connection a port-forward to a pod runs over
+type Dialer func(namespace, pod string) (httpstream.Dialer, error)
+
+// Client finds the members of Mk resources and connects to them. Dialer and Executor talk to
+// the api server through SPDY by default and can be replaced by fakes in tests.
+type Client struct {
+	KubeClient kubernetes.Interface
+	MkClient   mkclientset.Interface
+	Dialer     Dialer
+	Executor   Executor
+}
+
+// NewClient returns a client talking to the cluster of the given config
+func NewClient(config *rest.Config) (*Client, error) {
+	kubeClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	mkClient, err := mkclientset.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		KubeClient: kubeClient,
+		MkClient:   mkClient,
+		Dialer:     SPDYDialer(config, kubeClient),
+		Executor:   &SPDYExecutor{KubeClient: kubeClient, Config: config},
+	}, nil
+}
+
+// SPDYDialer dials the portforward subresource of pods
+func SPDYDialer(config *rest.Config, kubeClient kubernetes.Interface) Dialer {
+	return func(namespace, pod string) (httpstream.Dialer, error) {
+		transport, upgrader, err := spdy.RoundTripperFor(config)
+		if err != nil {
+			return nil, err
+		}
+
+		url := kubeClient.CoreV1().RESTClient().Post().
+			Resource("pods").
+			Namespace(namespace).
+			Name(pod).
+			SubResource("portforward").
+			URL()
+		return spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, url), nil
+	}
+}
+
+// PrimaryPod returns the Mk resource and the pod of its primary. The pods are found by the
+// label selector in the status of the Mk resource, the first ready one is asked for the primary.
+func (c *Client) PrimaryPod(ctx context.Context, namespace, name string) (*beta1.Mk, *v1.Pod, error) {
+	mkResource, err := c.MkClient.MongokubeBeta1().Mks(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	if mkResource.Status.Selector == "" {
+		return nil, nil, fmt.Errorf("mk/%s has no pods yet, its status is %q", name, mkResource.Status.Progress)
+	}
+
+	podList, err := c.KubeClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: mkResource.Status.Selector})
+	if err != nil {
+		return nil, nil, err
+	}
+	ready := map[string]*v1.Pod{}
+	var member string
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if IsPodReady(pod) {
+			ready[pod.Name] = pod
+			if member == "" {
+				member = pod.Name
+			}
+		}
+	}
+	if member == "" {
+		return nil, nil, fmt.Errorf("mk/%s has no ready pod", name)
+	}
+
+	out, err := c.Executor.Exec(ctx, namespace, member, mkResource.MongoContainerName(), []string{
+		"sh", "-c", `exec "$(command -v mongosh || command -v mongo)" --quiet --eval "$1"`, "sh", primaryScript,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to ask %s for the primary: %w", member, err)
+	}
+
+	// e.g. mongokube-test-mongodb-0.mongokube-test-mongodb-headless.mongokube-ns.svc.cluster.local:27017
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	primary, _, _ := strings.Cut(lines[len(lines)-1], ".")
+	pod, ok := ready[primary]
+	if !ok {
+		return nil, nil, fmt.Errorf("mk/%s has no ready primary", name)
+	}
+	return mkResource, pod, nil
+}
+
+// Credentials returns the username and password of the root user from the binding secret
+func (c *Client) Credentials(ctx context.Context, mkResource *beta1.Mk) (string, string, error) {
+	if mkResource.Status.Binding == nil {
+		return "", "", fmt.Errorf("mk/%s has no binding secret yet, its status is %q", mkResource.Name, mkResource.Status.Progress)
+	}
+
+	secret, err := c.KubeClient.CoreV1().Secrets(mkResource.Namespace).Get(ctx, mkResource.Status.Binding.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", "", err
+	}
+	return string(secret.Data["username"]), string(secret.Data["password"]), nil
+}
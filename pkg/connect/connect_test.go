@@ -0,0 +1,200 @@
+package connect
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+	mkfake "mongokube/pkg/client/clientset/versioned/fake"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeExecutor answers every command with out and err and records the pod it ran in
+type fakeExecutor struct {
+	out string
+	err error
+
+	pod       string
+	container string
+}
+
+func (e *fakeExecutor) Exec(ctx context.Context, namespace, pod, container string, command []string) (string, error) {
+	e.pod, e.container = pod, container
+	return e.out, e.err
+}
+
+func (e *fakeExecutor) Stream(ctx context.Context, namespace, pod, container string, command []string, stdin io.Reader, stdout io.Writer) error {
+	out, err := e.Exec(ctx, namespace, pod, container, command)
+	io.WriteString(stdout, out)
+	return err
+}
+
+func testPod(name string, ready bool) *v1.Pod {
+	status := v1.ConditionFalse
+	if ready {
+		status = v1.ConditionTrue
+	}
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"app": "test-mongodb"}},
+		Status:     v1.PodStatus{Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: status}}},
+	}
+}
+
+func TestPrimaryPod(t *testing.T) {
+	other := testPod("other-0", true)
+	other.Labels = map[string]string{"app": "other"}
+	primaryOut := "test-mongodb-1.test-mongodb-headless.default.svc.cluster.local:27017\n"
+	deleting := testPod("test-mongodb-0", true)
+	deleting.DeletionTimestamp = &metav1.Time{}
+	deleting.Finalizers = []string{"test"}
+
+	tests := []struct {
+		name     string
+		selector string
+		pods     []runtime.Object
+		out      string
+		execErr  error
+		want     string
+		wantPod  string
+		wantErr  string
+	}{
+		{
+			name:    "no selector",
+			pods:    []runtime.Object{testPod("test-mongodb-0", true)},
+			wantErr: "has no pods yet",
+		},
+		{
+			name:     "no ready pod",
+			selector: "app=test-mongodb",
+			pods:     []runtime.Object{testPod("test-mongodb-0", false), other},
+			wantErr:  "has no ready pod",
+		},
+		{
+			name:     "exec fails",
+			selector: "app=test-mongodb",
+			pods:     []runtime.Object{testPod("test-mongodb-0", true)},
+			execErr:  errors.New("container not found"),
+			wantPod:  "test-mongodb-0",
+			wantErr:  "failed to ask test-mongodb-0 for the primary",
+		},
+		{
+			name:     "primary not ready",
+			selector: "app=test-mongodb",
+			pods:     []runtime.Object{testPod("test-mongodb-0", true), testPod("test-mongodb-1", false)},
+			out:      primaryOut,
+			wantPod:  "test-mongodb-0",
+			wantErr:  "has no ready primary",
+		},
+		{
+			name:     "during an election",
+			selector: "app=test-mongodb",
+			pods:     []runtime.Object{testPod("test-mongodb-0", true), testPod("test-mongodb-1", true)},
+			out:      "undefined\n",
+			wantPod:  "test-mongodb-0",
+			wantErr:  "has no ready primary",
+		},
+		{
+			name:     "primary",
+			selector: "app=test-mongodb",
+			pods:     []runtime.Object{testPod("test-mongodb-0", true), testPod("test-mongodb-1", true)},
+			out:      "Warning: deprecated\n" + primaryOut,
+			wantPod:  "test-mongodb-0",
+			want:     "test-mongodb-1",
+		},
+		{
+			name:     "terminating pod is not asked",
+			selector: "app=test-mongodb",
+			pods:     []runtime.Object{deleting, testPod("test-mongodb-1", true)},
+			out:      primaryOut,
+			wantPod:  "test-mongodb-1",
+			want:     "test-mongodb-1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mkResource := &beta1.Mk{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+				Status:     beta1.MkStatus{Selector: tt.selector},
+			}
+			executor := &fakeExecutor{out: tt.out, err: tt.execErr}
+			client := &Client{
+				KubeClient: kubefake.NewSimpleClientset(tt.pods...),
+				MkClient:   mkfake.NewSimpleClientset(mkResource),
+				Executor:   executor,
+			}
+
+			gotMk, pod, err := client.PrimaryPod(context.Background(), "default", "test")
+			if executor.pod != tt.wantPod {
+				t.Errorf("asked pod %q for the primary, want %q", executor.pod, tt.wantPod)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if gotMk.Name != "test" || pod.Name != tt.want {
+				t.Errorf("PrimaryPod() = mk/%s, pod %s, want mk/test, pod %s", gotMk.Name, pod.Name, tt.want)
+			}
+			if executor.container != "test-container" {
+				t.Errorf("asked container %q, want test-container", executor.container)
+			}
+		})
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	mkResource := &beta1.Mk{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}}
+	client := &Client{KubeClient: kubefake.NewSimpleClientset(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-binding", Namespace: "default"},
+		Data:       map[string][]byte{"username": []byte("root"), "password": []byte("secret")},
+	})}
+
+	if _, _, err := client.Credentials(context.Background(), mkResource); err == nil {
+		t.Errorf("expected an error without a binding secret")
+	}
+
+	mkResource.Status.Binding = &beta1.BindingStatus{Name: "test-binding"}
+	username, password, err := client.Credentials(context.Background(), mkResource)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if username != "root" || password != "secret" {
+		t.Errorf("Credentials() = %q, %q, want root, secret", username, password)
+	}
+}
+
+func TestIsPodReady(t *testing.T) {
+	deleting := testPod("test-mongodb-0", true)
+	deleting.DeletionTimestamp = &metav1.Time{}
+
+	tests := []struct {
+		name string
+		pod  *v1.Pod
+		want bool
+	}{
+		{name: "ready", pod: testPod("test-mongodb-0", true), want: true},
+		{name: "not ready", pod: testPod("test-mongodb-0", false)},
+		{name: "no conditions", pod: &v1.Pod{}},
+		{name: "terminating", pod: deleting},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsPodReady(tt.pod); got != tt.want {
+				t.Errorf("IsPodReady() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package connect
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// Executor runs commands in a container of a pod
+type Executor interface {
+	// Exec returns what the command printed to stdout, stdout and stderr are added to the error
+	// if it fails
+	Exec(ctx context.Context, namespace, pod, container string, command []string) (string, error)
+	// Stream copies stdin, if not nil, to the command and its output to stdout. Stderr is added
+	// to the error if it fails.
+	Stream(ctx context.Context, namespace, pod, container string, command []string, stdin io.Reader, stdout io.Writer) error
+}
+
+// SPDYExecutor executes commands through the exec subresource of the pod
+type SPDYExecutor struct {
+	KubeClient kubernetes.Interface
+	Config     *rest.Config
+}
+
+func (e *SPDYExecutor) Exec(ctx context.Context, namespace, pod, container string, command []string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	err := e.stream(ctx, namespace, pod, container, command, remotecommand.StreamOptions{Stdout: &stdout, Stderr: &stderr})
+	if err != nil {
+		return stdout.String(), fmt.Errorf("exec in pod %s/%s: %w: %s", namespace, pod, err, strings.TrimSpace(stderr.String()+" "+stdout.String()))
+	}
+	return stdout.String(), nil
+}
+
+func (e *SPDYExecutor) Stream(ctx context.Context, namespace, pod, container string, command []string, stdin io.Reader, stdout io.Writer) error {
+	var stderr bytes.Buffer
+	err := e.stream(ctx, namespace, pod, container, command, remotecommand.StreamOptions{Stdin: stdin, Stdout: stdout, Stderr: &stderr})
+	if err != nil {
+		return fmt.Errorf("exec in pod %s/%s: %w: %s", namespace, pod, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (e *SPDYExecutor) stream(ctx context.Context, namespace, pod, container string, command []string, options remotecommand.StreamOptions) error {
+	req := e.KubeClient.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(pod).
+		SubResource("exec").
+		VersionedParams(&v1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdin:     options.Stdin != nil,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(e.Config, http.MethodPost, req.URL())
+	if err != nil {
+		return err
+	}
+	return executor.StreamWithContext(ctx, options)
+}
+
+// IsPodReady checks whether the pod passes its readiness probe and is not being deleted
+func IsPodReady(pod *v1.Pod) bool {
+	if pod.DeletionTimestamp != nil {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package connect
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/portforward"
+)
+
+// Forward is a running port-forward from a local port to MongoDB in a pod
+type Forward struct {
+	// Port on localhost, chosen by the system if 0 was requested
+	LocalPort uint16
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan error
+}
+
+// PortForward forwards localPort, or any free port if it is 0, on localhost to MongoDB in the pod.
+// It returns once the port is listening. The forward runs until Close is called or ctx is done.
+func (c *Client) PortForward(ctx context.Context, pod *v1.Pod, localPort uint16, errOut io.Writer) (*Forward, error) {
+	dialer, err := c.Dialer(pod.Namespace, pod.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	forward := &Forward{stop: make(chan struct{}), done: make(chan error, 1)}
+	ready := make(chan struct{})
+	forwarder, err := portforward.New(dialer, []string{fmt.Sprintf("%d:%d", localPort, MongoDbPort)}, forward.stop, ready, io.Discard, errOut)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		forward.done <- forwarder.ForwardPorts()
+	}()
+
+	select {
+	case <-ready:
+	case err := <-forward.done:
+		return nil, fmt.Errorf("failed to forward a port to %s: %w", pod.Name, err)
+	case <-ctx.Done():
+		forward.Close()
+		return nil, ctx.Err()
+	}
+
+	ports, err := forwarder.GetPorts()
+	if err != nil {
+		forward.Close()
+		return nil, err
+	}
+	forward.LocalPort = ports[0].Local
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			forward.Close()
+		case <-forward.stop:
+		}
+	}()
+	return forward, nil
+}
+
+// Done receives the result of the forward once it has stopped
+func (f *Forward) Done() <-chan error {
+	return f.done
+}
+
+// Close stops the forward
+func (f *Forward) Close() {
+	f.stopOnce.Do(func() { close(f.stop) })
+}
+
+// URI of the forwarded primary. The members advertise their names in the cluster, which cannot
+// be resolved locally, so the driver has to connect to the primary directly.
+func (f *Forward) URI(username, password string) string {
+	return fmt.Sprintf("mongodb://%s@localhost:%d/?directConnection=true&authSource=admin", url.UserPassword(username, password), f.LocalPort)
+}
+
+// Shell runs the local mongosh through the forward, authenticated as the given user, until it
+// exits or ctx is done. Command lines can be read by every user of the machine, so the password
+// is handed to mongosh in a script only the current user can read.
+func (f *Forward) Shell(ctx context.Context, username, password string, stdin io.Reader, stdout, stderr io.Writer) error {
+	script, err := writeShellScript(f.URI(username, password))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(script)
+
+	shell := exec.CommandContext(ctx, "mongosh", "--nodb", "--shell", script)
+	shell.Stdin, shell.Stdout, shell.Stderr = stdin, stdout, stderr
+	return shell.Run()
+}
+
+// Write a script connecting mongosh to the uri to a temporary file readable by the current user
+func writeShellScript(uri string) (string, error) {
+	quoted, err := json.Marshal(uri)
+	if err != nil {
+		return "", err
+	}
+
+	// created with mode 0600
+	f, err := os.CreateTemp("", "kubectl-mk-*.js")
+	if err != nil {
+		return "", err
+	}
+	_, err = fmt.Fprintf(f, "db = connect(%s);\n", quoted)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,267 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// Whether spec.adopt takes over a statefulset, the objects of an adopted deployment are replaced
+func adoptsStatefulSet(mkResource *beta1.Mk) bool {
+	return mkResource.Spec.Adopt != nil && mkResource.Spec.Adopt.StatefulSet != ""
+}
+
+// Name of the MongoDB statefulset, the adopted one if there is one
+func statefulSetName(mkResource *beta1.Mk) string {
+	if adoptsStatefulSet(mkResource) {
+		return mkResource.Spec.Adopt.StatefulSet
+	}
+	return mkResource.Name + "-mongodb"
+}
+
+// Name of the headless service of the MongoDB statefulset
+func headlessServiceName(mkResource *beta1.Mk) string {
+	if adoptsStatefulSet(mkResource) {
+		return mkResource.Spec.Adopt.Service
+	}
+	return mkResource.Name + "-mongodb-headless"
+}
+
+// Name of the secret with the credentials of the root user
+func mongoSecretName(mkResource *beta1.Mk) string {
+	if mkResource.Spec.Adopt != nil && mkResource.Spec.Adopt.Secret != "" {
+		return mkResource.Spec.Adopt.Secret
+	}
+	return "mongodb-secret"
+}
+
+// Take over the statefulset, headless service and secret in spec.adopt. Before the statefulset is
+// controlled by the Mk resource they are checked by validateAdoption, afterwards createOrUpdate
+// sets the owner references like for any other object. Returns the adopted statefulset, nil
+// without spec.adopt or with an adopted deployment, whose data is copied by migrateDeployment.
+func (c *Controller) adopt(ctx context.Context, mkResource *beta1.Mk) (*appsv1.StatefulSet, error) {
+	adopt := mkResource.Spec.Adopt
+	if adopt == nil {
+		return nil, nil
+	}
+	if err := validateAdoptedSpec(mkResource); err != nil {
+		return nil, c.adoptionFailed(mkResource, err)
+	}
+	if adopt.Deployment != "" {
+		return nil, c.checkAdoptedDeployment(ctx, mkResource)
+	}
+
+	statefulSet, err := c.k8sclient.AppsV1().StatefulSets(mkResource.Namespace).Get(ctx, adopt.StatefulSet, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		// Deployments are not taken over but copied, see adopt.deployment
+		if _, deploymentErr := c.k8sclient.AppsV1().Deployments(mkResource.Namespace).Get(ctx, adopt.StatefulSet, metav1.GetOptions{}); deploymentErr == nil {
+			err = fmt.Errorf("%s is a Deployment, set adopt.deployment instead of adopt.statefulSet and adopt.service to copy its data into a new replica set", adopt.StatefulSet)
+		}
+	}
+	if err != nil {
+		return nil, c.adoptionFailed(mkResource, err)
+	}
+	if owner := metav1.GetControllerOf(statefulSet); owner != nil && owner.UID == mkResource.UID {
+		return statefulSet, nil
+	}
+
+	service, err := c.k8sclient.CoreV1().Services(mkResource.Namespace).Get(ctx, adopt.Service, metav1.GetOptions{})
+	if err != nil {
+		return nil, c.adoptionFailed(mkResource, err)
+	}
+
+	var secret *v1.Secret
+	if adopt.Secret != "" {
+		secret, err = c.k8sclient.CoreV1().Secrets(mkResource.Namespace).Get(ctx, adopt.Secret, metav1.GetOptions{})
+		if err != nil {
+			return nil, c.adoptionFailed(mkResource, err)
+		}
+	}
+
+	if err := validateAdoption(mkResource, statefulSet, service, secret); err != nil {
+		return nil, c.adoptionFailed(mkResource, err)
+	}
+
+	if dryRunFrom(ctx) == nil {
+		klog.FromContext(ctx).Info("Adopting statefulset", "statefulSet", statefulSet.Name, "service", service.Name)
+		c.recorder.Eventf(mkResource, v1.EventTypeNormal, reasonAdopted, "Adopted StatefulSet %s and Service %s", statefulSet.Name, service.Name)
+	}
+	return statefulSet, nil
+}
+
+// Record why the objects in spec.adopt cannot be taken over
+func (c *Controller) adoptionFailed(mkResource *beta1.Mk, err error) error {
+	if errors.IsNotFound(err) {
+		err = fmt.Errorf("%s to adopt does not exist", strings.TrimSuffix(err.Error(), " not found"))
+	}
+	c.recorder.Eventf(mkResource, v1.EventTypeWarning, reasonAdoptionFailed, "Cannot adopt: %v", err)
+	return err
+}
+
+// Check that exactly one of a statefulset and a deployment is adopted, and for a statefulset
+// that the spec needs no change of the pod template, which adopted statefulsets keep
+func validateAdoptedSpec(mkResource *beta1.Mk) error {
+	adopt := mkResource.Spec.Adopt
+	if (adopt.StatefulSet == "") == (adopt.Deployment == "") {
+		return fmt.Errorf("either adopt.statefulSet or adopt.deployment has to be set")
+	}
+	if adopt.Deployment != "" {
+		if adopt.Service != "" || adopt.Container != "" {
+			return fmt.Errorf("adopt.service and adopt.container cannot be used with adopt.deployment")
+		}
+		return nil
+	}
+	if adopt.Service == "" {
+		return fmt.Errorf("adopt.service is required with adopt.statefulSet")
+	}
+
+	var features []string
+	if monitoringEnabled(mkResource) {
+		features = append(features, "monitoring")
+	}
+	if mkResource.Spec.MongodConfig != nil {
+		features = append(features, "mongodConfig")
+	}
+	if len(mkResource.Spec.InitScripts) > 0 {
+		features = append(features, "initScripts")
+	}
+	if externalTLS(mkResource) != nil {
+		features = append(features, "service.external.tls")
+	}
+	if len(features) > 0 {
+		return fmt.Errorf("%s cannot be used with adopt, the pod template of an adopted statefulset is kept", strings.Join(features, ", "))
+	}
+	return nil
+}
+
+// Check that the controller can take over the statefulset without restarting its members or
+// losing data: it keeps its data in volume claims, runs spec.mongoDbImage as replica set rs0 with
+// the root credentials in its environment, and its members are reachable through the headless service.
+func validateAdoption(mkResource *beta1.Mk, statefulSet *appsv1.StatefulSet, service *v1.Service, secret *v1.Secret) error {
+	if owner := metav1.GetControllerOf(statefulSet); owner != nil {
+		return fmt.Errorf("StatefulSet %s is already controlled by %s %s", statefulSet.Name, owner.Kind, owner.Name)
+	}
+	if len(statefulSet.Spec.Selector.MatchExpressions) > 0 {
+		return fmt.Errorf("StatefulSet %s selects its pods with expressions, only matchLabels are supported", statefulSet.Name)
+	}
+	if len(statefulSet.Spec.VolumeClaimTemplates) == 0 {
+		return fmt.Errorf("StatefulSet %s has no volumeClaimTemplates, its data would be lost when members are restarted", statefulSet.Name)
+	}
+
+	if statefulSet.Spec.ServiceName != service.Name {
+		return fmt.Errorf("Service %s is not the service of StatefulSet %s, which is %s", service.Name, statefulSet.Name, statefulSet.Spec.ServiceName)
+	}
+	if service.Spec.ClusterIP != v1.ClusterIPNone {
+		return fmt.Errorf("Service %s is not headless", service.Name)
+	}
+	if owner := metav1.GetControllerOf(service); owner != nil {
+		return fmt.Errorf("Service %s is already controlled by %s %s", service.Name, owner.Kind, owner.Name)
+	}
+
+	container := mongoContainer(mkResource, statefulSet)
+	if container == nil {
+		return fmt.Errorf("StatefulSet %s has no container %s, set adopt.container to the name of its MongoDB container", statefulSet.Name, mkResource.MongoContainerName())
+	}
+	if container.Image != mkResource.Spec.MongoDbImage {
+		return fmt.Errorf("StatefulSet %s runs %s, set mongoDbImage to that image and upgrade after adoption", statefulSet.Name, container.Image)
+	}
+
+	replSet := ""
+	args := append(append([]string{}, container.Command...), container.Args...)
+	for i, arg := range args {
+		name, value, found := strings.Cut(arg, "=")
+		if name != "--replSet" {
+			continue
+		}
+		if !found && i+1 < len(args) {
+			value = args[i+1]
+		}
+		replSet = value
+	}
+	if replSet == "" {
+		return fmt.Errorf("StatefulSet %s runs standalone members, which cannot join a replica set without a restart; start them with --replSet %s first", statefulSet.Name, beta1.ReplicaSetName)
+	}
+	if replSet != beta1.ReplicaSetName {
+		return fmt.Errorf("StatefulSet %s runs replica set %s, only replica set %s can be adopted", statefulSet.Name, replSet, beta1.ReplicaSetName)
+	}
+
+	// the controller runs the MongoDB shell with these credentials, variables of envFrom cannot be checked
+	if len(container.EnvFrom) == 0 {
+		for _, name := range []string{"MONGO_INITDB_ROOT_USERNAME", "MONGO_INITDB_ROOT_PASSWORD"} {
+			if !hasEnv(container, name) {
+				return fmt.Errorf("container %s of StatefulSet %s has no %s, the root credentials have to be in its environment", container.Name, statefulSet.Name, name)
+			}
+		}
+	}
+
+	if secret != nil {
+		if string(secret.Data["username"]) != mkResource.Spec.DbUsername || string(secret.Data["password"]) != mkResource.Spec.DbPassword {
+			return fmt.Errorf("Secret %s does not hold dbUsername and dbPassword in the keys username and password", secret.Name)
+		}
+		if owner := metav1.GetControllerOf(secret); owner != nil {
+			return fmt.Errorf("Secret %s is already controlled by %s %s", secret.Name, owner.Kind, owner.Name)
+		}
+	}
+
+	return nil
+}
+
+// Check that the deployment in spec.adopt can be copied: it has to exist until the replica set
+// has been created, afterwards it is deleted once its data has been copied, and it must not be
+// controlled by anything else.
+func (c *Controller) checkAdoptedDeployment(ctx context.Context, mkResource *beta1.Mk) error {
+	name := mkResource.Spec.Adopt.Deployment
+	deployment, err := c.k8sclient.AppsV1().Deployments(mkResource.Namespace).Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err := c.k8sclient.AppsV1().StatefulSets(mkResource.Namespace).Get(ctx, statefulSetName(mkResource), metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return c.adoptionFailed(mkResource, fmt.Errorf("Deployment %s to adopt does not exist", name))
+		}
+		return err
+	}
+	if err != nil {
+		return c.adoptionFailed(mkResource, err)
+	}
+	if owner := metav1.GetControllerOf(deployment); owner != nil {
+		return c.adoptionFailed(mkResource, fmt.Errorf("Deployment %s is already controlled by %s %s", name, owner.Kind, owner.Name))
+	}
+	return nil
+}
+
+// Whether the container sets the environment variable
+func hasEnv(container *v1.Container, name string) bool {
+	for _, env := range container.Env {
+		if env.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Change the desired statefulset so that it can replace the adopted one without restarting its
+// members: selector, volume claims and pod management policy cannot be changed, and the pod
+// template is kept, so that the members stay up to date. Only the image of the MongoDB container
+// follows image, so that upgrades are rolled out.
+func adoptStatefulSet(mkResource *beta1.Mk, desired *appsv1.StatefulSet, adopted *appsv1.StatefulSet, image string) {
+	desiredImage := image
+	if container := mongoContainer(mkResource, desired); container != nil {
+		desiredImage = container.Image
+	}
+
+	desired.Spec.Selector = adopted.Spec.Selector
+	desired.Spec.PodManagementPolicy = adopted.Spec.PodManagementPolicy
+	desired.Spec.VolumeClaimTemplates = adopted.Spec.VolumeClaimTemplates
+	desired.Spec.Template = *adopted.Spec.Template.DeepCopy()
+
+	if container := mongoContainer(mkResource, desired); container != nil && container.Image != image {
+		container.Image = desiredImage
+	}
+}
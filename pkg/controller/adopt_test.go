@@ -0,0 +1,307 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+func adoptingMk() *beta1.Mk {
+	mkResource := testMk()
+	mkResource.Spec.Adopt = &beta1.AdoptSpec{StatefulSet: "mongo", Service: "mongo-headless", Container: "mongo"}
+	return mkResource
+}
+
+// StatefulSet created by hand which adoptingMk can take over
+func adoptableStatefulSet() *appsv1.StatefulSet {
+	replicas := int32(3)
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "mongo", Namespace: "default"},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:    &replicas,
+			ServiceName: "mongo-headless",
+			Selector:    &metav1.LabelSelector{MatchLabels: map[string]string{"app": "mongo"}},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "mongo"}},
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{{
+						Name:  "mongo",
+						Image: "mongo:7.0",
+						Args:  []string{"--replSet", "rs0", "--bind_ip_all"},
+						Env: []v1.EnvVar{
+							{Name: "MONGO_INITDB_ROOT_USERNAME", Value: "root"},
+							{Name: "MONGO_INITDB_ROOT_PASSWORD", Value: "secret"},
+						},
+						VolumeMounts: []v1.VolumeMount{{Name: "mongo-data", MountPath: "/data/db"}},
+					}},
+				},
+			},
+			VolumeClaimTemplates: []v1.PersistentVolumeClaim{{ObjectMeta: metav1.ObjectMeta{Name: "mongo-data"}}},
+		},
+	}
+}
+
+func TestValidateAdoption(t *testing.T) {
+	headless := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "mongo-headless", Namespace: "default"},
+		Spec:       v1.ServiceSpec{ClusterIP: v1.ClusterIPNone},
+	}
+	owner := metav1.NewControllerRef(&beta1.Mk{ObjectMeta: metav1.ObjectMeta{Name: "other", UID: "other-uid"}}, beta1.SchemeGroupVersion.WithKind("Mk"))
+
+	tests := []struct {
+		name    string
+		mutate  func(mkResource *beta1.Mk, statefulSet *appsv1.StatefulSet, service *v1.Service, secret *v1.Secret)
+		wantErr string
+	}{
+		{
+			name:   "adoptable",
+			mutate: func(*beta1.Mk, *appsv1.StatefulSet, *v1.Service, *v1.Secret) {},
+		},
+		{
+			name: "replica set argument with equals sign",
+			mutate: func(_ *beta1.Mk, statefulSet *appsv1.StatefulSet, _ *v1.Service, _ *v1.Secret) {
+				statefulSet.Spec.Template.Spec.Containers[0].Args = []string{"--replSet=rs0"}
+			},
+		},
+		{
+			name: "credentials from envFrom",
+			mutate: func(_ *beta1.Mk, statefulSet *appsv1.StatefulSet, _ *v1.Service, _ *v1.Secret) {
+				container := &statefulSet.Spec.Template.Spec.Containers[0]
+				container.Env = nil
+				container.EnvFrom = []v1.EnvFromSource{{SecretRef: &v1.SecretEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: "mongo-root"}}}}
+			},
+		},
+		{
+			name: "controlled by someone else",
+			mutate: func(_ *beta1.Mk, statefulSet *appsv1.StatefulSet, _ *v1.Service, _ *v1.Secret) {
+				statefulSet.OwnerReferences = []metav1.OwnerReference{*owner}
+			},
+			wantErr: "already controlled",
+		},
+		{
+			name: "selector expressions",
+			mutate: func(_ *beta1.Mk, statefulSet *appsv1.StatefulSet, _ *v1.Service, _ *v1.Secret) {
+				statefulSet.Spec.Selector.MatchExpressions = []metav1.LabelSelectorRequirement{{Key: "tier", Operator: metav1.LabelSelectorOpExists}}
+			},
+			wantErr: "expressions",
+		},
+		{
+			name: "no volume claims",
+			mutate: func(_ *beta1.Mk, statefulSet *appsv1.StatefulSet, _ *v1.Service, _ *v1.Secret) {
+				statefulSet.Spec.VolumeClaimTemplates = nil
+			},
+			wantErr: "volumeClaimTemplates",
+		},
+		{
+			name: "other service",
+			mutate: func(_ *beta1.Mk, statefulSet *appsv1.StatefulSet, _ *v1.Service, _ *v1.Secret) {
+				statefulSet.Spec.ServiceName = "mongo"
+			},
+			wantErr: "not the service",
+		},
+		{
+			name: "service not headless",
+			mutate: func(_ *beta1.Mk, _ *appsv1.StatefulSet, service *v1.Service, _ *v1.Secret) {
+				service.Spec.ClusterIP = "10.0.0.1"
+			},
+			wantErr: "not headless",
+		},
+		{
+			name: "container not found",
+			mutate: func(mkResource *beta1.Mk, _ *appsv1.StatefulSet, _ *v1.Service, _ *v1.Secret) {
+				mkResource.Spec.Adopt.Container = ""
+			},
+			wantErr: "adopt.container",
+		},
+		{
+			name: "other image",
+			mutate: func(mkResource *beta1.Mk, _ *appsv1.StatefulSet, _ *v1.Service, _ *v1.Secret) {
+				mkResource.Spec.MongoDbImage = "mongo:7.0.5"
+			},
+			wantErr: "set mongoDbImage",
+		},
+		{
+			name: "standalone",
+			mutate: func(_ *beta1.Mk, statefulSet *appsv1.StatefulSet, _ *v1.Service, _ *v1.Secret) {
+				statefulSet.Spec.Template.Spec.Containers[0].Args = []string{"--bind_ip_all"}
+			},
+			wantErr: "standalone",
+		},
+		{
+			name: "standalone single member",
+			mutate: func(_ *beta1.Mk, statefulSet *appsv1.StatefulSet, _ *v1.Service, _ *v1.Secret) {
+				replicas := int32(1)
+				statefulSet.Spec.Replicas = &replicas
+				statefulSet.Spec.Template.Spec.Containers[0].Args = nil
+			},
+			wantErr: "standalone",
+		},
+		{
+			name: "other replica set",
+			mutate: func(_ *beta1.Mk, statefulSet *appsv1.StatefulSet, _ *v1.Service, _ *v1.Secret) {
+				statefulSet.Spec.Template.Spec.Containers[0].Args = []string{"--replSet", "prod"}
+			},
+			wantErr: "replica set prod",
+		},
+		{
+			name: "no credentials",
+			mutate: func(_ *beta1.Mk, statefulSet *appsv1.StatefulSet, _ *v1.Service, _ *v1.Secret) {
+				statefulSet.Spec.Template.Spec.Containers[0].Env = nil
+			},
+			wantErr: "MONGO_INITDB_ROOT_USERNAME",
+		},
+		{
+			name: "secret with other credentials",
+			mutate: func(_ *beta1.Mk, _ *appsv1.StatefulSet, _ *v1.Service, secret *v1.Secret) {
+				secret.Data["password"] = []byte("other")
+			},
+			wantErr: "does not hold",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mkResource := adoptingMk()
+			mkResource.Spec.Adopt.Secret = "mongo-root"
+			statefulSet := adoptableStatefulSet()
+			service := headless.DeepCopy()
+			secret := &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "mongo-root", Namespace: "default"},
+				Data:       map[string][]byte{"username": []byte("root"), "password": []byte("secret")},
+			}
+			tt.mutate(mkResource, statefulSet, service, secret)
+
+			err := validateAdoption(mkResource, statefulSet, service, secret)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateAdoptedSpec(t *testing.T) {
+	mkResource := adoptingMk()
+	if err := validateAdoptedSpec(mkResource); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mkResource.Spec.Monitoring = &beta1.MonitoringSpec{}
+	mkResource.Spec.MongodConfig = &beta1.MongodConfigSpec{Inline: "operationProfiling:\n  mode: slowOp\n"}
+	err := validateAdoptedSpec(mkResource)
+	if err == nil || !strings.Contains(err.Error(), "monitoring, mongodConfig") {
+		t.Errorf("error = %v, want one naming monitoring and mongodConfig", err)
+	}
+
+	// the pod template of an adopted deployment is not kept
+	mkResource.Spec.Adopt = &beta1.AdoptSpec{Deployment: "mongo"}
+	if err := validateAdoptedSpec(mkResource); err != nil {
+		t.Errorf("unexpected error for a deployment: %v", err)
+	}
+
+	for _, adopt := range []beta1.AdoptSpec{
+		{},
+		{StatefulSet: "mongo"},
+		{StatefulSet: "mongo", Service: "mongo-headless", Deployment: "mongo"},
+		{Deployment: "mongo", Service: "mongo-headless"},
+		{Deployment: "mongo", Container: "mongo"},
+	} {
+		mkResource.Spec.Adopt = &adopt
+		if err := validateAdoptedSpec(mkResource); err == nil {
+			t.Errorf("no error for %+v", adopt)
+		}
+	}
+}
+
+func TestAdoptDeployment(t *testing.T) {
+	mkResource := testMk()
+	mkResource.Spec.Adopt = &beta1.AdoptSpec{Deployment: "mongo"}
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "mongo", Namespace: "default"}}
+	controlled := deployment.DeepCopy()
+	controlled.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "other"}}, appsv1.SchemeGroupVersion.WithKind("Deployment"))}
+	statefulSet := testStatefulSet(mkResource, nil, nil)
+
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		wantErr string
+	}{
+		{name: "deployment", objects: []runtime.Object{deployment}},
+		{name: "deleted after its data was copied", objects: []runtime.Object{statefulSet}},
+		{name: "missing", wantErr: "Deployment mongo to adopt does not exist"},
+		{name: "controlled by someone else", objects: []runtime.Object{controlled}, wantErr: "already controlled by Deployment other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := testController(mkResource, tt.objects...)
+
+			adopted, err := c.adopt(context.Background(), mkResource)
+			if adopted != nil {
+				t.Errorf("adopt() = %s, want no statefulset", adopted.Name)
+			}
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if event := <-c.recorder.(*record.FakeRecorder).Events; !strings.Contains(event, reasonAdoptionFailed) {
+				t.Errorf("event = %q, want %s", event, reasonAdoptionFailed)
+			}
+		})
+	}
+
+	// a deployment named as statefulset is rejected with a hint to adopt.deployment
+	mkResource.Spec.Adopt = &beta1.AdoptSpec{StatefulSet: "mongo", Service: "mongo-headless"}
+	c, _ := testController(mkResource, deployment)
+	if _, err := c.adopt(context.Background(), mkResource); err == nil || !strings.Contains(err.Error(), "adopt.deployment") {
+		t.Errorf("error = %v, want one pointing to adopt.deployment", err)
+	}
+}
+
+func TestAdoptStatefulSet(t *testing.T) {
+	mkResource := adoptingMk()
+	adopted := adoptableStatefulSet()
+
+	desired := testStatefulSet(mkResource, nil, nil)
+	adoptStatefulSet(mkResource, desired, adopted, mkResource.Spec.MongoDbImage)
+	if !equality.Semantic.DeepEqual(desired.Spec.Template, adopted.Spec.Template) {
+		t.Errorf("pod template = %v, want the adopted one %v", desired.Spec.Template, adopted.Spec.Template)
+	}
+	if !equality.Semantic.DeepEqual(desired.Spec.Selector, adopted.Spec.Selector) ||
+		!equality.Semantic.DeepEqual(desired.Spec.VolumeClaimTemplates, adopted.Spec.VolumeClaimTemplates) {
+		t.Errorf("selector or volume claims of the adopted statefulset are not kept")
+	}
+	if desired.Spec.UpdateStrategy.Type != appsv1.OnDeleteStatefulSetStrategyType {
+		t.Errorf("update strategy = %s, want OnDelete", desired.Spec.UpdateStrategy.Type)
+	}
+
+	// upgrades only change the image
+	upgrading := mkResource.DeepCopy()
+	upgrading.Spec.MongoDbImage = "mongo:8.0"
+	desired = testStatefulSet(upgrading, nil, nil)
+	adoptStatefulSet(upgrading, desired, adopted, upgrading.Spec.MongoDbImage)
+	want := adopted.Spec.Template.DeepCopy()
+	want.Spec.Containers[0].Image = "mongo:8.0"
+	if !equality.Semantic.DeepEqual(&desired.Spec.Template, want) {
+		t.Errorf("pod template = %v, want %v", desired.Spec.Template, want)
+	}
+}
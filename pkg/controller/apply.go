@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog/v2"
+)
+
+// kubeObject is implemented by the pointer types of all objects created for a Mk resource
+type kubeObject interface {
+	metav1.Object
+	runtime.Object
+}
+
+// objectClient is satisfied by the typed clients of client-go, e.g. CoreV1().Secrets(namespace)
+type objectClient[T kubeObject] interface {
+	Get(ctx context.Context, name string, opts metav1.GetOptions) (T, error)
+	Create(ctx context.Context, obj T, opts metav1.CreateOptions) (T, error)
+	Update(ctx context.Context, obj T, opts metav1.UpdateOptions) (T, error)
+	Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error
+}
+
+// Create the desired object if it does not exist yet. Otherwise mutate copies the fields
+// owned by the controller onto the existing object and reports whether anything changed,
+// in which case the existing object is updated. A nil mutate leaves existing objects alone.
+// Objects are controlled by the Mk resource, so they are garbage collected with it and
+// changes to them are mapped back to it. Existing objects which are controlled by someone
+// else, or by no one and may not be taken over according to mayTakeOver, are left alone.
+// Every change, or failure to change, is recorded as an event on the Mk resource. In a dry-run
+// context the change is only recorded in the plan.
+func createOrUpdate[T kubeObject](ctx context.Context, c *Controller, mkResource *beta1.Mk, client objectClient[T], desired T, mutate func(existing T) bool) (T, error) {
+	logger := klog.FromContext(ctx)
+	kind := kindOf(desired)
+	setOwnership(mkResource, desired)
+	existing, err := client.Get(ctx, desired.GetName(), metav1.GetOptions{})
+	plan := dryRunFrom(ctx)
+
+	if errors.IsNotFound(err) {
+		created, err := client.Create(ctx, desired, createOptions(ctx))
+		if err != nil {
+			c.recorder.Eventf(mkResource, v1.EventTypeWarning, reasonFailedCreate, "Failed to create %s %s: %v", kind, desired.GetName(), err)
+			return created, err
+		}
+
+		if plan != nil {
+			plan.add("create", kind, desired.GetName())
+			return created, nil
+		}
+
+		logger.V(2).Info("Created object", "kind", kind, "name", desired.GetName())
+		c.recorder.Eventf(mkResource, v1.EventTypeNormal, reasonCreated, "Created %s %s", kind, desired.GetName())
+		return created, nil
+	}
+
+	if err != nil {
+		return existing, err
+	}
+
+	if owner := metav1.GetControllerOf(existing); owner != nil && owner.UID != mkResource.UID {
+		err := fmt.Errorf("%s %s is already controlled by %s %s", kind, desired.GetName(), owner.Kind, owner.Name)
+		c.recorder.Event(mkResource, v1.EventTypeWarning, reasonFailedUpdate, err.Error())
+		return existing, err
+	} else if owner == nil && !mayTakeOver(mkResource, existing) {
+		err := fmt.Errorf("%s %s already exists and is not controlled by Mk %s, delete or rename it", kind, desired.GetName(), mkResource.Name)
+		c.recorder.Event(mkResource, v1.EventTypeWarning, reasonFailedUpdate, err.Error())
+		return existing, err
+	}
+
+	changed := setOwnership(mkResource, existing)
+	if mutate != nil && mutate(existing) {
+		changed = true
+	}
+
+	if !changed {
+		return existing, nil
+	}
+
+	updated, err := client.Update(ctx, existing, updateOptions(ctx))
+	if err != nil {
+		c.recorder.Eventf(mkResource, v1.EventTypeWarning, reasonFailedUpdate, "Failed to update %s %s: %v", kind, desired.GetName(), err)
+		return updated, err
+	}
+
+	if plan != nil {
+		plan.add("update", kind, desired.GetName())
+		return updated, nil
+	}
+
+	logger.V(2).Info("Updated object", "kind", kind, "name", desired.GetName())
+	c.recorder.Eventf(mkResource, v1.EventTypeNormal, reasonUpdated, "Updated %s %s", kind, desired.GetName())
+	return updated, nil
+}
+
+// Delete an object which is not needed anymore, if it exists and is controlled by the Mk resource.
+// The deletion, or failure to delete, is recorded as an event on the Mk resource.
+func deleteIfOwned[T kubeObject](ctx context.Context, c *Controller, mkResource *beta1.Mk, client objectClient[T], name string) error {
+	existing, err := client.Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if owner := metav1.GetControllerOf(existing); owner == nil || owner.UID != mkResource.UID {
+		return nil
+	}
+
+	kind := kindOf(existing)
+	err = client.Delete(ctx, name, deleteOptions(ctx))
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		c.recorder.Eventf(mkResource, v1.EventTypeWarning, reasonFailedDelete, "Failed to delete %s %s: %v", kind, name, err)
+		return err
+	}
+
+	if plan := dryRunFrom(ctx); plan != nil {
+		plan.add("delete", kind, name)
+		return nil
+	}
+
+	klog.FromContext(ctx).V(2).Info("Deleted object", "kind", kind, "name", name)
+	c.recorder.Eventf(mkResource, v1.EventTypeNormal, reasonDeleted, "Deleted %s %s", kind, name)
+	return nil
+}
+
+// Add the controller reference to the Mk resource and the managed-by label if they are missing
+func setOwnership(mkResource *beta1.Mk, obj metav1.Object) bool {
+	changed := false
+
+	if metav1.GetControllerOf(obj) == nil {
+		ownerReference := metav1.NewControllerRef(mkResource, beta1.SchemeGroupVersion.WithKind("Mk"))
+		obj.SetOwnerReferences(append(obj.GetOwnerReferences(), *ownerReference))
+		changed = true
+	}
+
+	if obj.GetLabels()[managedByLabel] != managedByValue {
+		objLabels := map[string]string{}
+		for k, v := range obj.GetLabels() {
+			objLabels[k] = v
+		}
+		objLabels[managedByLabel] = managedByValue
+		obj.SetLabels(objLabels)
+		changed = true
+	}
+
+	return changed
+}
+
+// Kind of an object, e.g. Secret for *v1.Secret. Typed objects usually have no kind set,
+// unstructured objects always do.
+func kindOf(obj runtime.Object) string {
+	if kind := obj.GetObjectKind().GroupVersionKind().Kind; kind != "" {
+		return kind
+	}
+	return reflect.TypeOf(obj).Elem().Name()
+}
@@ -0,0 +1,145 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestCreateOrUpdateTakeOver(t *testing.T) {
+	mkResource := testMk()
+	mkResource.UID = "mk-uid"
+	adopting := testMk()
+	adopting.UID = "mk-uid"
+	adopting.Spec.Adopt = &beta1.AdoptSpec{StatefulSet: "mongo", Service: "mongo-headless"}
+
+	otherController := metav1.NewControllerRef(&beta1.Mk{ObjectMeta: metav1.ObjectMeta{Name: "other", UID: "other-uid"}}, beta1.SchemeGroupVersion.WithKind("Mk"))
+	secret := func(username, password string) *v1.Secret {
+		return &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "mongodb-secret", Namespace: "default"},
+			Data:       map[string][]byte{"username": []byte(username), "password": []byte(password)},
+		}
+	}
+	service := func(name string, selector map[string]string) *v1.Service {
+		return &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       v1.ServiceSpec{Selector: selector, Ports: []v1.ServicePort{{Port: 27017}}},
+		}
+	}
+	controlledBy := func(obj *v1.Service, ref *metav1.OwnerReference) *v1.Service {
+		obj.OwnerReferences = []metav1.OwnerReference{*ref}
+		return obj
+	}
+
+	tests := []struct {
+		name       string
+		mkResource *beta1.Mk
+		existing   runtime.Object
+		desired    func(mkResource *beta1.Mk) runtime.Object
+		takenOver  bool
+	}{
+		{
+			name:       "secret of an earlier release",
+			mkResource: mkResource,
+			existing:   secret("root", "secret"),
+			takenOver:  true,
+		},
+		{
+			name:       "secret of someone else",
+			mkResource: mkResource,
+			existing:   secret("app", "app"),
+		},
+		{
+			name:       "service of an earlier release",
+			mkResource: mkResource,
+			existing:   service("mongodb-service", mongoLabels(mkResource)),
+			desired: func(mkResource *beta1.Mk) runtime.Object {
+				return buildMongoService(mkResource, mongoDbServiceConfig(mkResource, testStatefulSet(mkResource, nil, nil)))
+			},
+			takenOver: true,
+		},
+		{
+			name:       "service of another instance",
+			mkResource: mkResource,
+			existing:   service("mongodb-service", map[string]string{"app": "otherdb"}),
+			desired: func(mkResource *beta1.Mk) runtime.Object {
+				return buildMongoService(mkResource, mongoDbServiceConfig(mkResource, testStatefulSet(mkResource, nil, nil)))
+			},
+		},
+		{
+			name:       "service controlled by another instance",
+			mkResource: mkResource,
+			existing:   controlledBy(service("mongodb-service", mongoLabels(mkResource)), otherController),
+			desired: func(mkResource *beta1.Mk) runtime.Object {
+				return buildMongoService(mkResource, mongoDbServiceConfig(mkResource, testStatefulSet(mkResource, nil, nil)))
+			},
+		},
+		{
+			name:       "headless service of another statefulset",
+			mkResource: mkResource,
+			existing:   service("test-mongodb-headless", map[string]string{"app": "mongo"}),
+			desired: func(mkResource *beta1.Mk) runtime.Object {
+				return buildMongoHeadlessService(mkResource)
+			},
+		},
+		{
+			name:       "adopted headless service",
+			mkResource: adopting,
+			existing:   service("mongo-headless", map[string]string{"app": "mongo"}),
+			desired: func(mkResource *beta1.Mk) runtime.Object {
+				return buildMongoHeadlessService(mkResource)
+			},
+			takenOver: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			c := &Controller{recorder: recorder}
+			clientset := fake.NewSimpleClientset(tt.existing)
+			ctx := context.Background()
+
+			var err error
+			var result metav1.Object
+			switch existing := tt.existing.(type) {
+			case *v1.Secret:
+				desired := buildSecret(tt.mkResource)
+				_, err = createOrUpdate(ctx, c, tt.mkResource, clientset.CoreV1().Secrets("default"), desired, secretMutator(desired))
+				result, _ = clientset.CoreV1().Secrets("default").Get(ctx, existing.Name, metav1.GetOptions{})
+			case *v1.Service:
+				desired := tt.desired(tt.mkResource).(*v1.Service)
+				_, err = createOrUpdate(ctx, c, tt.mkResource, clientset.CoreV1().Services("default"), desired, serviceMutator(desired))
+				result, _ = clientset.CoreV1().Services("default").Get(ctx, existing.Name, metav1.GetOptions{})
+			}
+
+			owner := metav1.GetControllerOf(result)
+			if tt.takenOver {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if owner == nil || owner.UID != tt.mkResource.UID {
+					t.Errorf("controller = %v, want the Mk resource", owner)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("object is taken over")
+			}
+			if owner != nil && owner.UID == tt.mkResource.UID {
+				t.Errorf("object is controlled by the Mk resource")
+			}
+			if len(recorder.Events) == 0 {
+				t.Errorf("no event recorded")
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Type of the binding secret as defined by the Service Binding specification
+const bindingSecretType v1.SecretType = "servicebinding.io/mongodb"
+
+// Name of the secret applications bind to
+func bindingSecretName(mkResource *beta1.Mk) string {
+	return mkResource.Name + "-binding"
+}
+
+// Create the secret with everything an application needs to connect, laid out as in the
+// Service Binding specification. The connection strings list the current replica set members,
+// so the secret is updated whenever the credentials or the number of members change.
+func (c *Controller) createBindingSecret(ctx context.Context, mkResource *beta1.Mk, mongoDbService *v1.Service, headlessService *v1.Service, statefulSetName string) (*v1.Secret, error) {
+	secret := buildBindingSecret(mkResource, mongoDbService, headlessService, statefulSetName)
+	return createOrUpdate(ctx, c, mkResource, c.k8sclient.CoreV1().Secrets(mkResource.Namespace), secret, secretMutator(secret))
+}
+
+// Binding secret with the connection details of the given services
+func buildBindingSecret(mkResource *beta1.Mk, mongoDbService *v1.Service, headlessService *v1.Service, statefulSetName string) *v1.Secret {
+	credentials := url.UserPassword(mkResource.Spec.DbUsername, mkResource.Spec.DbPassword).String()
+	options := fmt.Sprintf("replicaSet=%s&authSource=admin", beta1.ReplicaSetName)
+
+	hosts := make([]string, mkResource.Replicas())
+	for i := range hosts {
+		hosts[i] = fmt.Sprintf("%s-%d.%s.%s.svc.%s:%d", statefulSetName, i, headlessService.Name, mkResource.Namespace, clusterDomain, 27017)
+	}
+
+	// The SRV records of the named port of the headless service list all members
+	srvHost := fmt.Sprintf("%s.%s.svc.%s", headlessService.Name, mkResource.Namespace, clusterDomain)
+
+	fields := map[string]string{
+		"type":     "mongodb",
+		"provider": "mongokube",
+		"host":     fmt.Sprintf("%s.%s.svc.%s", mongoDbService.Name, mkResource.Namespace, clusterDomain),
+		"port":     strconv.Itoa(int(mongoDbService.Spec.Ports[0].Port)),
+		"username": mkResource.Spec.DbUsername,
+		"password": mkResource.Spec.DbPassword,
+		"uri":      fmt.Sprintf("mongodb://%s@%s/?%s", credentials, strings.Join(hosts, ","), options),
+		// mongodb+srv enables TLS by default, which the members do not serve
+		"uri-srv": fmt.Sprintf("mongodb+srv://%s@%s/?%s&tls=false", credentials, srvHost, options),
+	}
+
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      bindingSecretName(mkResource),
+			Namespace: mkResource.Namespace,
+		},
+		Type: bindingSecretType,
+		Data: map[string][]byte{},
+	}
+	for k, v := range fields {
+		secret.Data[k] = []byte(v)
+	}
+
+	return secret
+}
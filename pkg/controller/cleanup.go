@@ -0,0 +1,95 @@
+package controller
+
+import (
+	"context"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// Delete the secrets and ConfigMaps of features which have been removed from the spec. Members
+// read them when their containers start, so they are only deleted once the pod template of the
+// statefulset no longer references them; the caller makes sure that all members run that template.
+func (c *Controller) deleteUnused(ctx context.Context, mkResource *beta1.Mk, statefulSet *appsv1.StatefulSet) error {
+	template := &statefulSet.Spec.Template
+
+	if !monitoringEnabled(mkResource) && !referencesSecret(template, monitoringSecretName(mkResource)) {
+		if err := deleteIfOwned(ctx, c, mkResource, c.k8sclient.CoreV1().Secrets(mkResource.Namespace), monitoringSecretName(mkResource)); err != nil {
+			return err
+		}
+	}
+
+	configMaps := c.k8sclient.CoreV1().ConfigMaps(mkResource.Namespace)
+	if mkResource.Spec.MongodConfig == nil && !referencesConfigMap(template, mongodConfigMapName(mkResource)) {
+		if err := deleteIfOwned(ctx, c, mkResource, configMaps, mongodConfigMapName(mkResource)); err != nil {
+			return err
+		}
+	}
+
+	if len(mkResource.Spec.InitScripts) == 0 && !referencesConfigMap(template, initMarkerConfigMapName(mkResource)) {
+		if err := deleteIfOwned(ctx, c, mkResource, configMaps, initMarkerConfigMapName(mkResource)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Whether the containers or volumes of a pod template use the secret
+func referencesSecret(template *v1.PodTemplateSpec, name string) bool {
+	secrets, _ := templateReferences(template)
+	return secrets.Has(name)
+}
+
+// Whether the containers or volumes of a pod template use the ConfigMap
+func referencesConfigMap(template *v1.PodTemplateSpec, name string) bool {
+	_, configMaps := templateReferences(template)
+	return configMaps.Has(name)
+}
+
+// Names of the secrets and ConfigMaps used by the containers and volumes of a pod template
+func templateReferences(template *v1.PodTemplateSpec) (secrets sets.Set[string], configMaps sets.Set[string]) {
+	secrets, configMaps = sets.New[string](), sets.New[string]()
+
+	for _, volume := range template.Spec.Volumes {
+		if volume.Secret != nil {
+			secrets.Insert(volume.Secret.SecretName)
+		}
+		if volume.ConfigMap != nil {
+			configMaps.Insert(volume.ConfigMap.Name)
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.Secret != nil {
+					secrets.Insert(source.Secret.Name)
+				}
+				if source.ConfigMap != nil {
+					configMaps.Insert(source.ConfigMap.Name)
+				}
+			}
+		}
+	}
+
+	for _, container := range append(append([]v1.Container{}, template.Spec.InitContainers...), template.Spec.Containers...) {
+		for _, env := range container.Env {
+			if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil {
+				secrets.Insert(env.ValueFrom.SecretKeyRef.Name)
+			}
+			if env.ValueFrom != nil && env.ValueFrom.ConfigMapKeyRef != nil {
+				configMaps.Insert(env.ValueFrom.ConfigMapKeyRef.Name)
+			}
+		}
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.SecretRef != nil {
+				secrets.Insert(envFrom.SecretRef.Name)
+			}
+			if envFrom.ConfigMapRef != nil {
+				configMaps.Insert(envFrom.ConfigMapRef.Name)
+			}
+		}
+	}
+	return secrets, configMaps
+}
@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"testing"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestReferencesSecret(t *testing.T) {
+	mkResource := testMk()
+	monitoringSecret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: monitoringSecretName(mkResource)}}
+	projected := testStatefulSet(mkResource, nil, nil)
+	projected.Spec.Template.Spec.Volumes = append(projected.Spec.Template.Spec.Volumes, v1.Volume{
+		Name: "projected",
+		VolumeSource: v1.VolumeSource{Projected: &v1.ProjectedVolumeSource{Sources: []v1.VolumeProjection{
+			{Secret: &v1.SecretProjection{LocalObjectReference: v1.LocalObjectReference{Name: "scripts"}}},
+		}}},
+	})
+
+	tests := []struct {
+		name     string
+		template *v1.PodTemplateSpec
+		secret   string
+		want     bool
+	}{
+		{"exporter", &testStatefulSet(mkResource, monitoringSecret, nil).Spec.Template, monitoringSecret.Name, true},
+		{"no exporter", &testStatefulSet(mkResource, nil, nil).Spec.Template, monitoringSecret.Name, false},
+		{"root credentials", &testStatefulSet(mkResource, nil, nil).Spec.Template, mongoSecretName(mkResource), true},
+		{"keyfile volume", &testStatefulSet(mkResource, nil, nil).Spec.Template, mkResource.Name + "-keyfile", true},
+		{"projected volume", &projected.Spec.Template, "scripts", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := referencesSecret(tt.template, tt.secret); got != tt.want {
+				t.Errorf("referencesSecret(%s) = %v, want %v", tt.secret, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReferencesConfigMap(t *testing.T) {
+	mkResource := testMk()
+	mongodConfig := buildMongodConfigMap(mkResource, "")
+
+	if !referencesConfigMap(&testStatefulSet(mkResource, nil, mongodConfig).Spec.Template, mongodConfig.Name) {
+		t.Errorf("mounted mongod.conf is not found")
+	}
+	if referencesConfigMap(&testStatefulSet(mkResource, nil, nil).Spec.Template, mongodConfig.Name) {
+		t.Errorf("mongod.conf is found without mongodConfig")
+	}
+
+	// the marker is projected into the init scripts volume
+	mkResource.Spec.InitScripts = []beta1.InitScriptSource{{ConfigMap: &beta1.LocalObjectReference{Name: "seed"}}}
+	marker := buildInitMarkerConfigMap(mkResource)
+	template := testStatefulSet(mkResource, nil, nil).Spec.Template
+	template.Spec.Volumes = append(template.Spec.Volumes, initScriptsVolume(mkResource, marker))
+	if !referencesConfigMap(&template, marker.Name) || !referencesConfigMap(&template, "seed") {
+		t.Errorf("init scripts are not found")
+	}
+}
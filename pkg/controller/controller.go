@@ -2,36 +2,92 @@ package controller
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"fmt"
 	"time"
 
 	"mongokube/pkg/apis/mongokube/beta1"
 	mkclientset "mongokube/pkg/client/clientset/versioned"
+	mkscheme "mongokube/pkg/client/clientset/versioned/scheme"
 	mkinformers "mongokube/pkg/client/informers/externalversions/mongokube/beta1"
 	mklister "mongokube/pkg/client/listers/mongokube/beta1"
+	"mongokube/pkg/connect"
+	"mongokube/pkg/metrics"
 
 	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/uuid"
 
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	"golang.org/x/time/rate"
 )
 
 const (
 	port = "50051"
+
+	// Values of status.progress
+	progressProvisioning = "Provisioning"
+	progressScaling      = "Scaling"
+	progressRunning      = "Running"
+	progressPaused       = "Paused"
+	progressMaintenance  = "Maintenance"
+	progressUpgrading    = "Upgrading"
+	progressUpdating     = "Updating"
+	progressMigrating    = "Migrating"
 )
 
 // Controller Struct which has attributes k8s standard clientset, Mk generated clientset
 // generated lister, cache and workqueue
 type Controller struct {
-	k8sclient   kubernetes.Clientset
-	mkClient    mkclientset.Interface
-	mkLister    mklister.MkLister
-	mkSynched   cache.InformerSynced //if cache has been synched with api server
-	mkWorkQueue workqueue.RateLimitingInterface
+	k8sclient     kubernetes.Interface
+	mkClient      mkclientset.Interface
+	dynamicClient dynamic.Interface // for custom resources of other operators, e.g. ServiceMonitors
+	mkLister      mklister.MkLister
+	mkSynched     []cache.InformerSynced //if caches of Mk resources and owned objects have been synched with api server
+	mkWorkQueue   workqueue.RateLimitingInterface
+	executor      connect.Executor // runs the mongo shell inside the database pods
+	discovery     discoveryCache
+	recorder      record.EventRecorder
+	broadcaster   record.EventBroadcaster
+	options       Options
+	logger        klog.Logger // used by the event handlers, reconciles log through their context
+}
+
+// Options tune the concurrency and retry behaviour of the controller
+type Options struct {
+	// Number of Mk resources which are reconciled in parallel
+	Workers int
+	// Bounds of the per item exponential backoff after failed reconciles
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// Rate at which items are added back to the queue, shared by all items
+	QueueQPS   float64
+	QueueBurst int
+	// Time to wait for in-flight reconciles on shutdown
+	ShutdownTimeout time.Duration
+	// Registry all images are pulled from instead of the one in their name, e.g. a mirror
+	ImageRegistry string
+	// Only plan the changes to every Mk resource instead of applying them
+	DryRun bool
 }
 
 // This struct will represent the data for mongodb and mongo express service
@@ -41,171 +97,633 @@ type MongoService struct {
 	serviceType v1.ServiceType
 	port        int32
 	nodePort    int32
+	metricsPort int32 // port of the exporter sidecar, if any
+	targetPort  int32 // port of the pods, if it differs from port
+
+	annotations              map[string]string
+	loadBalancerSourceRanges []string
+	externalTrafficPolicy    v1.ServiceExternalTrafficPolicy
 }
 
 // Initialize the Controller struct and add event handler for registering
 // handler functions for adding and deleting Mk resources.
 func NewController(
-	k8sclient kubernetes.Clientset,
+	k8sclient kubernetes.Interface,
 	mkClient mkclientset.Interface,
-	mkInformer mkinformers.MkInformer,
-
+	mkInformers map[string]mkinformers.MkInformer,
+	kubeInformers []kubeinformers.SharedInformerFactory,
+	metadataInformers []metadatainformer.SharedInformerFactory,
+	config *rest.Config,
+	options Options,
 ) *Controller {
+	// Same as workqueue.DefaultControllerRateLimiter, with configurable limits
+	rateLimiter := workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(options.BaseBackoff, options.MaxBackoff),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(options.QueueQPS), options.QueueBurst)},
+	)
+
+	// Events are recorded on Mk resources, so their types need to be known to the scheme
+	utilruntime.Must(mkscheme.AddToScheme(scheme.Scheme))
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: k8sclient.CoreV1().Events("")})
+	broadcaster.StartStructuredLogging(4)
+
 	c := &Controller{
-		k8sclient:   k8sclient,
-		mkClient:    mkClient,
-		mkLister:    mkInformer.Lister(),
-		mkSynched:   mkInformer.Informer().HasSynced,
-		mkWorkQueue: workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "mongokube"),
+		k8sclient:     k8sclient,
+		mkClient:      mkClient,
+		dynamicClient: dynamic.NewForConfigOrDie(config),
+		mkWorkQueue:   workqueue.NewNamedRateLimitingQueue(rateLimiter, "mongokube"),
+		executor:      &connect.SPDYExecutor{KubeClient: k8sclient, Config: config},
+		recorder:      broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "mongokube"}),
+		broadcaster:   broadcaster,
+		options:       options,
+		logger:        klog.Background(),
+	}
+
+	// There is one informer per watched namespace, or a single one for all namespaces
+	mkListers := multiNamespaceMkLister{}
+	for namespace, mkInformer := range mkInformers {
+		mkInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    c.handleAdd,
+			UpdateFunc: c.handleUpdate,
+			DeleteFunc: c.handleDel,
+		})
+		mkListers[namespace] = mkInformer.Lister()
+		c.mkSynched = append(c.mkSynched, mkInformer.Informer().HasSynced)
 	}
+	c.mkLister = mkListers
 
-	mkInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc:    c.handleAdd,
-		DeleteFunc: c.handleDel,
-	})
+	// Changes to owned objects enqueue their Mk resource, so that drift is corrected right away
+	for _, factory := range kubeInformers {
+		for _, informer := range []cache.SharedIndexInformer{
+			factory.Apps().V1().StatefulSets().Informer(),
+			factory.Apps().V1().Deployments().Informer(),
+			factory.Core().V1().Services().Informer(),
+			factory.Core().V1().Secrets().Informer(),
+			factory.Policy().V1().PodDisruptionBudgets().Informer(),
+			factory.Networking().V1().NetworkPolicies().Informer(),
+		} {
+			informer.AddEventHandler(c.ownedObjectHandler())
+			c.mkSynched = append(c.mkSynched, informer.HasSynced)
+		}
+	}
+
+	// ConfigMaps referenced through spec.mongodConfig are created by the user, so all of them are
+	// watched, but only their metadata is cached
+	for _, factory := range metadataInformers {
+		informer := factory.ForResource(v1.SchemeGroupVersion.WithResource("configmaps")).Informer()
+		informer.AddEventHandler(c.configMapHandler())
+		c.mkSynched = append(c.mkSynched, informer.HasSynced)
+	}
 
 	return c
 }
 
 // Add objects to queue
 func (c *Controller) handleAdd(obj interface{}) {
-	c.mkWorkQueue.Add(obj)
-	fmt.Printf("Handling a Mk resource\n")
+	c.enqueue(obj)
+	c.logger.V(4).Info("Handling a Mk resource", "mk", klog.KObj(obj.(*beta1.Mk)))
 }
 
-// Delete objects to queue
+// Add updated objects to queue, e.g. when spec.replicas has been changed through the scale subresource
+func (c *Controller) handleUpdate(oldObj, newObj interface{}) {
+	c.enqueue(newObj)
+}
+
+// Deleted objects do not need to be processed
 func (c *Controller) handleDel(obj interface{}) {
-	c.mkWorkQueue.Done(obj)
-	fmt.Printf("Deleting a Mk resource\n")
+	key, _ := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	c.logger.V(4).Info("Deleting a Mk resource", "key", key)
+}
+
+// Add the namespace/name key of an object to the queue
+func (c *Controller) enqueue(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.mkWorkQueue.Add(key)
+}
+
+// Process the object again after the given duration, used while waiting for
+// pods or replica set members to become ready
+func (c *Controller) requeueAfter(mkResource *beta1.Mk, after time.Duration) {
+	key, err := cache.MetaNamespaceKeyFunc(mkResource)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.mkWorkQueue.AddAfter(key, after)
 }
 
 // Specifying the receiver of the method to be of type pointer to controller
 // Run will set up the event handlers for types we are interested in, as well
-// as syncing informer caches and starting workers. It will block until stopCh
-// is closed, at which point it will shutdown the workqueue and wait for
-// workers to finish processing their current work items.
-func (c *Controller) Run(channel <-chan struct{}) {
-	// Takes receive-only channel as argument
+// as syncing informer caches and starting workers. It will block until ctx
+// is cancelled, at which point it will shutdown the workqueue and wait up to
+// the shutdown timeout for workers to finish processing their current work items.
+// Reconciles run with workCtx, which is cancelled once the shutdown timeout has
+// passed; cancelling workCtx itself, e.g. when leadership is lost, aborts them
+// right away. Run returns only after all workers have stopped.
+func (c *Controller) Run(ctx context.Context, workCtx context.Context) {
+	logger := klog.FromContext(ctx)
+
 	// wait for the cache inside the informer to be synched before starting workers
-	if !cache.WaitForCacheSync(channel, c.mkSynched) {
-		fmt.Print("Waiting for cache to be synched\n")
+	logger.Info("Waiting for cache to be synched")
+	if !cache.WaitForCacheSync(ctx.Done(), c.mkSynched...) {
+		logger.Info("Cache was not synched before shutdown")
+		c.mkWorkQueue.ShutDown()
+		return
+	}
+
+	workCtx, cancelWork := context.WithCancel(workCtx)
+	defer cancelWork()
+
+	//Create goroutines to call the worker function after every 1 second till the context is cancelled
+	logger.Info("Starting workers", "count", c.options.Workers)
+	var workers wait.Group
+	for i := 0; i < c.options.Workers; i++ {
+		workers.StartWithChannel(ctx.Done(), func(stopCh <-chan struct{}) {
+			wait.Until(func() { c.worker(workCtx) }, time.Second, stopCh)
+		})
 	}
 
-	//Create goroutine to call the worker function after every 1 second till the channel is stopped
-	go wait.Until(c.worker, time.Second, channel)
+	//Wait until the context is cancelled
+	<-ctx.Done()
+	logger.Info("Shutting down workers")
+
+	// Items which are queued or being processed are finished, afterwards
+	// Get reports the shutdown and the workers return
+	finished := make(chan struct{})
+	go func() {
+		c.mkWorkQueue.ShutDownWithDrain()
+		workers.Wait()
+		close(finished)
+	}()
 
-	//Wait until some object is added into channel
-	<-channel
+	select {
+	case <-finished:
+		logger.Info("Workers finished")
+	case <-workCtx.Done():
+		logger.Info("Reconciles in flight are cancelled")
+		<-finished
+	case <-time.After(c.options.ShutdownTimeout):
+		logger.Info("Workers did not finish in time, cancelling them", "timeout", c.options.ShutdownTimeout)
+		cancelWork()
+		<-finished
+	}
+
+	// Flushes the events which have been recorded so far
+	c.broadcaster.Shutdown()
+}
+
+// HasSynced reports whether the informer cache has been synched with the api server
+func (c *Controller) HasSynced() bool {
+	for _, synched := range c.mkSynched {
+		if !synched() {
+			return false
+		}
+	}
+	return true
 }
 
-func (c *Controller) worker() {
+// Lister returns the Mk resources managed by the controller
+func (c *Controller) Lister() mklister.MkLister {
+	return c.mkLister
+}
+
+func (c *Controller) worker(ctx context.Context) {
 	// loop till processItem returns true, on false it will wait for a second and then again this function will be called by run()
-	for c.processNextItem() {
+	for c.processNextItem(ctx) {
 
 	}
 }
 
 // Process the items from queue
-func (c *Controller) processNextItem() bool {
-	fmt.Printf("Processing the items from queue %v\n", c.mkWorkQueue.Len())
+func (c *Controller) processNextItem(ctx context.Context) bool {
+	logger := klog.FromContext(ctx)
+	logger.V(4).Info("Processing the items from queue", "length", c.mkWorkQueue.Len())
 	item, shutdown := c.mkWorkQueue.Get()
 
-	// Delete the item from queue, so that we wont process it again
-	defer c.mkWorkQueue.Forget(item)
-
 	if shutdown {
 		return false
 	}
 
-	// Generating key for each item in queue
-	key, err := cache.MetaNamespaceKeyFunc(item)
-	if err != nil {
-		fmt.Printf("Getting key from cache %s\n", err.Error())
-		return false
+	// Mark the item as done, so that it can be processed again if it is added to the queue
+	defer c.mkWorkQueue.Done(item)
+
+	// Cancelled work is not started anymore, the queue is drained without it
+	if ctx.Err() != nil {
+		return true
+	}
+
+	// Items in the queue are namespace/name keys
+	key, ok := item.(string)
+	if !ok {
+		c.mkWorkQueue.Forget(item)
+		logger.Error(nil, "Expected string in workqueue", "item", item)
+		return true
 	}
 
 	// Getting namespace, name from genrated key
 	ns, name, err := cache.SplitMetaNamespaceKey(key)
 	if err != nil {
-		fmt.Printf("Getting namespace and name from MetaNamespaceKeyFunc %s\n", err.Error())
-		return false
+		c.mkWorkQueue.Forget(item)
+		logger.Error(err, "Getting namespace and name from MetaNamespaceKeyFunc", "key", key)
+		return true
 	}
 
+	// Every log line of this reconcile carries the Mk resource and a reconcile ID
+	logger = klog.LoggerWithValues(logger, "mk", klog.KRef(ns, name), "reconcileID", uuid.NewUUID())
+	ctx = klog.NewContext(ctx, logger)
+
 	mkResource, err := c.mkLister.Mks(ns).Get(name)
 
-	if err != nil {
-		fmt.Printf("Error getting Mk resource %s\n", err.Error())
-		return false
+	if errors.IsNotFound(err) {
+		// Mk resource has been deleted in the meantime
+		c.mkWorkQueue.Forget(item)
+		return true
 	}
 
-	// %+v for printing struct
-	fmt.Printf("Mk resource specs are :%+v\n", mkResource.Spec)
+	if err != nil {
+		logger.Error(err, "Error getting Mk resource")
+		c.mkWorkQueue.AddRateLimited(item)
+		return true
+	}
 
-	// Handle Mk resource
-	deployment := c.handleMkResource(mkResource)
+	// the spec is logged field by field, so that dbPassword never ends up in the logs
+	logger.V(2).Info("Reconciling Mk resource")
+	logger.V(4).Info("Mk resource specs", redactedSpec(mkResource)...)
 
-	if deployment {
-		c.mkWorkQueue.Forget(item)
+	// Handle Mk resource, retry with backoff on failure
+	start := time.Now()
+	if err := c.handleMkResource(ctx, mkResource); err != nil {
+		metrics.ObserveReconcile(metrics.ResultError, time.Since(start))
+		logger.Error(err, "Failed to handle mk resource")
+		c.recorder.Event(mkResource, v1.EventTypeWarning, reasonReconcileFailed, err.Error())
+		c.mkWorkQueue.AddRateLimited(item)
+		return true
 	}
+	metrics.ObserveReconcile(metrics.ResultSuccess, time.Since(start))
+
+	// Delete the item from the rate limiter, so that we start with no backoff next time
+	c.mkWorkQueue.Forget(item)
 
 	return true
 }
 
-// Handle mk resource whenever it is created and added to queue
-func (c *Controller) handleMkResource(mkResource *beta1.Mk) bool {
-	fmt.Printf("Creating a secret for mk resource: %s\n", mkResource.Name)
-	secret, err := c.createSecret(mkResource)
+// Handle mk resource whenever it is created, updated or resynched
+func (c *Controller) handleMkResource(ctx context.Context, mkResource *beta1.Mk) error {
+	logger := klog.FromContext(ctx)
+
+	if mkResource.Paused() {
+		logger.V(2).Info("Reconciling is paused, only reporting status")
+		return c.updatePausedStatus(ctx, mkResource)
+	}
+	if mkResource.Status.Progress == progressPaused {
+		c.recorder.Event(mkResource, v1.EventTypeNormal, reasonResumed, "Resumed reconciling")
+	}
+
+	// Objects are only created, updated and deleted as server-side dry-run, the replica set is left alone
+	var plan *dryRunPlan
+	if c.isDryRun(mkResource) {
+		logger.V(2).Info("Dry-run is enabled, only planning changes")
+		ctx, plan = withDryRun(ctx)
+	}
+
+	// Services the api server would refuse are reported before anything is changed
+	if err := validateServiceSpec(mkResource.Spec.Service); err != nil {
+		c.recorder.Event(mkResource, v1.EventTypeWarning, reasonInvalidConfig, err.Error())
+		return fmt.Errorf("invalid service: %w", err)
+	}
+
+	// An adopted statefulset is checked before anything is created next to it
+	adopted, err := c.adopt(ctx, mkResource)
 	if err != nil {
-		fmt.Printf("Failed to create secret: %s\n", err.Error())
+		return fmt.Errorf("failed to adopt: %w", err)
 	}
 
-	fmt.Printf("Creating MongoDB deployment for mk resource: %s\n", mkResource.Name)
-	deployment, err := c.createMongoDeployment(mkResource, secret)
+	logger.V(2).Info("Creating a secret")
+	secret, err := c.createSecret(ctx, mkResource)
 	if err != nil {
-		fmt.Printf("Failed to create deployment: %s\n", err.Error())
+		return fmt.Errorf("failed to create secret: %w", err)
 	}
 
-	mongodbService := &MongoService{
-		name:        "mongodb-service",
-		label:       deployment.Labels,
-		serviceType: v1.ServiceTypeClusterIP,
-		port:        27017,
+	logger.V(2).Info("Creating a replica set keyfile")
+	keyfile, err := c.createKeyfileSecret(ctx, mkResource)
+	if err != nil {
+		return fmt.Errorf("failed to create keyfile secret: %w", err)
+	}
+
+	logger.V(2).Info("Creating MongoDB headless service")
+	headlessService, err := c.createMongoHeadlessService(ctx, mkResource, adopted)
+	if err != nil {
+		return fmt.Errorf("failed to create mongo db headless service: %w", err)
+	}
+
+	logger.V(2).Info("Creating MongoDB pod disruption budget")
+	if _, err := c.createPodDisruptionBudget(ctx, mkResource, adopted); err != nil {
+		return fmt.Errorf("failed to create pod disruption budget: %w", err)
 	}
 
-	fmt.Printf("Creating MongoDB internal service for mk resource: %s\n", mkResource.Name)
-	mongoDbService, err := c.createMongoService(mkResource, *mongodbService)
+	logger.V(2).Info("Creating MongoDB network policy")
+	if _, err := c.createNetworkPolicy(ctx, mkResource, adopted); err != nil {
+		return fmt.Errorf("failed to create network policy: %w", err)
+	}
 
+	// A changed spec.mongoDbImage is only rolled out if the upgrade is supported
+	upgrade, err := c.planUpgrade(ctx, mkResource)
 	if err != nil {
-		fmt.Printf("Failed to create mongo db service: %s\n", err.Error())
+		return fmt.Errorf("failed to plan upgrade: %w", err)
 	}
 
-	fmt.Printf("Creating MongoExpress deployment for mk resource: %s\n", mkResource.Name)
-	mongoExpressDeployment, err := c.createMongoExpressDeployment(mkResource, secret, mongoDbService)
+	logger.V(2).Info("Creating monitoring credentials")
+	monitoringSecret, err := c.createMonitoringSecret(ctx, mkResource)
+	if err != nil {
+		return fmt.Errorf("failed to create monitoring secret: %w", err)
+	}
 
+	logger.V(2).Info("Creating mongod configuration")
+	mongodConfig, err := c.createMongodConfigMap(ctx, mkResource)
 	if err != nil {
-		fmt.Printf("Failed to create mongo db service: %s\n", err.Error())
+		return fmt.Errorf("failed to create mongod configuration: %w", err)
 	}
 
-	mongoExpressService := &MongoService{
-		name:        "mongoexpress-service",
-		label:       mongoExpressDeployment.Labels,
-		serviceType: v1.ServiceTypeLoadBalancer,
-		port:        8081,
-		nodePort:    31000,
+	logger.V(2).Info("Creating init script marker")
+	initMarker, err := c.createInitMarkerConfigMap(ctx, mkResource)
+	if err != nil {
+		return fmt.Errorf("failed to create init script marker: %w", err)
 	}
 
-	fmt.Printf("Creating MongoExpress external service for mk resource: %s\n", mkResource.Name)
-	_, err = c.createMongoService(mkResource, *mongoExpressService)
+	// A dry-run update does not change the statefulset, its members are planned from the current one
+	var previous *appsv1.StatefulSet
+	if plan != nil {
+		previous, err = c.k8sclient.AppsV1().StatefulSets(mkResource.Namespace).Get(ctx, statefulSetName(mkResource), metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			previous, err = nil, nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get statefulset: %w", err)
+		}
+	}
 
+	logger.V(2).Info("Creating MongoDB statefulset")
+	statefulSet, err := c.createMongoStatefulSet(ctx, mkResource, upgrade.image(), secret, keyfile, monitoringSecret, mongodConfig, initMarker, headlessService, adopted)
 	if err != nil {
-		fmt.Printf("Failed to create mongo express service: %s\n", err.Error())
+		return fmt.Errorf("failed to create statefulset: %w", err)
 	}
 
-	return true
+	logger.V(2).Info("Creating MongoDB internal service")
+	mongoDbService, err := c.createMongoService(ctx, mkResource, mongoDbServiceConfig(mkResource, statefulSet))
+
+	if err != nil {
+		return fmt.Errorf("failed to create mongo db service: %w", err)
+	}
+
+	logger.V(2).Info("Creating MongoDB external services")
+	horizons, err := c.createExternalServices(ctx, mkResource, statefulSet)
+	if err != nil {
+		return fmt.Errorf("failed to create external services: %w", err)
+	}
+
+	logger.V(2).Info("Creating binding secret")
+	if _, err := c.createBindingSecret(ctx, mkResource, mongoDbService, headlessService, statefulSet.Name); err != nil {
+		return fmt.Errorf("failed to create binding secret: %w", err)
+	}
+
+	logger.V(2).Info("Creating ServiceMonitor")
+	if err := c.createServiceMonitor(ctx, mkResource, mongoDbService); err != nil {
+		return fmt.Errorf("failed to create service monitor: %w", err)
+	}
+
+	logger.V(2).Info("Creating MongoExpress deployment")
+	_, err = c.createMongoExpressDeployment(ctx, mkResource, secret, mongoDbService)
+
+	if err != nil {
+		return fmt.Errorf("failed to create mongo express deployment: %w", err)
+	}
+
+	logger.V(2).Info("Creating MongoExpress external service")
+	_, err = c.createMongoService(ctx, mkResource, mongoExpressServiceConfig(mkResource))
+
+	if err != nil {
+		return fmt.Errorf("failed to create mongo express service: %w", err)
+	}
+
+	if plan != nil {
+		// the statefulset is the one the dry-run update returned, so unused objects are planned for deletion
+		if err := c.deleteUnused(ctx, mkResource, statefulSet); err != nil {
+			return fmt.Errorf("failed to delete unused objects: %w", err)
+		}
+		if upgrade.inProgress() {
+			plan.changes = append(plan.changes, fmt.Sprintf("upgrade members from %s to %s", upgrade.current, upgrade.target))
+		} else {
+			planMembers(mkResource, previous, statefulSet, plan)
+		}
+		if err := c.planMigration(ctx, mkResource, plan); err != nil {
+			return fmt.Errorf("failed to plan migration: %w", err)
+		}
+		return c.reportPlan(ctx, mkResource, plan)
+	}
+
+	// Members are left alone during maintenance, so that they can be changed by hand
+	if mkResource.InMaintenance() {
+		if mkResource.Status.Progress != progressMaintenance {
+			c.recorder.Event(mkResource, v1.EventTypeNormal, reasonMaintenance, "Instance is under maintenance, Mongo Express is scaled to zero and replica set members are not managed")
+		}
+		status := mkResource.Status.DeepCopy()
+		status.Progress = progressMaintenance
+		upgrade.report(status)
+		return c.updateStatus(ctx, mkResource, statefulSet, status)
+	}
+
+	// Initiate the replica set and add or remove members until it matches spec.replicas
+	progress := progressRunning
+	done, err := c.reconcileReplicaSet(ctx, mkResource, statefulSet, horizons)
+	if err != nil {
+		return fmt.Errorf("failed to reconcile replica set: %w", err)
+	}
+	if !done {
+		progress = progressProvisioning
+		if mkResource.Status.Progress == progressRunning || mkResource.Status.Progress == progressScaling {
+			progress = progressScaling
+		}
+	}
+
+	status := mkResource.Status.DeepCopy()
+
+	// The monitoring user and the initialization marker are found on the primary
+	needsPrimary := monitoringEnabled(mkResource) || (len(mkResource.Spec.InitScripts) > 0 && status.Initialization == "")
+	if done && needsPrimary {
+		primaryPod, err := c.primaryPod(ctx, mkResource, statefulSet)
+		if err != nil {
+			return fmt.Errorf("failed to find primary: %w", err)
+		}
+		if primaryPod == "" {
+			done = false
+		}
+
+		if primaryPod != "" && monitoringEnabled(mkResource) {
+			if err := c.createMonitoringUser(ctx, mkResource, primaryPod, monitoringSecret); err != nil {
+				return fmt.Errorf("failed to create monitoring user: %w", err)
+			}
+		}
+
+		if primaryPod != "" && len(mkResource.Spec.InitScripts) > 0 && status.Initialization == "" {
+			status.Initialization, err = c.initialization(ctx, mkResource, primaryPod)
+			if err != nil {
+				return fmt.Errorf("failed to check initialization: %w", err)
+			}
+		}
+	}
+
+	// The data of the pods of an adopted deployment, or of the deployment run by earlier
+	// releases, is moved into the running replica set
+	if done {
+		done, err = c.migrateDeployment(ctx, mkResource, statefulSet)
+		if err != nil {
+			return fmt.Errorf("failed to migrate deployment: %w", err)
+		}
+		if !done {
+			progress = progressMigrating
+		}
+	}
+
+	// Members are only upgraded while the replica set is complete
+	if done && upgrade.inProgress() {
+		done, err = c.reconcileUpgrade(ctx, mkResource, statefulSet, upgrade)
+		if err != nil {
+			return fmt.Errorf("failed to upgrade replica set: %w", err)
+		}
+		if done {
+			c.recorder.Eventf(mkResource, v1.EventTypeNormal, reasonUpgraded, "Upgraded all members from %s to %s", upgrade.current, upgrade.target)
+			upgrade.current = upgrade.target
+		}
+		setRolloutCondition(status, mkResource.Generation, upgrade.rollout)
+	}
+	if upgrade.inProgress() {
+		progress = progressUpgrading
+	} else if done {
+		// Changes of the pod template, e.g. of mongodConfig, are rolled out one member at a time
+		rollout, err := c.rollMembers(ctx, mkResource, statefulSet, "with the new pod template")
+		if err != nil {
+			return fmt.Errorf("failed to roll out statefulset: %w", err)
+		}
+		setRolloutCondition(status, mkResource.Generation, rollout)
+		if !rollout.done {
+			done = false
+			progress = progressUpdating
+		}
+	}
+
+	// Objects of removed features are kept until every member runs the template without them
+	if done {
+		if err := c.deleteUnused(ctx, mkResource, statefulSet); err != nil {
+			return fmt.Errorf("failed to delete unused objects: %w", err)
+		}
+	} else {
+		c.requeueAfter(mkResource, 10*time.Second)
+	}
+
+	if progress == progressRunning && mkResource.Status.Progress != progressRunning {
+		c.recorder.Eventf(mkResource, v1.EventTypeNormal, reasonRunning, "Replica set %s is running with %d members", beta1.ReplicaSetName, mkResource.Replicas())
+	}
+
+	status.Progress = progress
+	upgrade.report(status)
+	return c.updateStatus(ctx, mkResource, statefulSet, status)
+}
+
+// Update the status of mk resource to the given status, completed with the state of the MongoDB
+// statefulset. status.replicas and status.selector are read by the scale subresource.
+func (c *Controller) updateStatus(ctx context.Context, mkResource *beta1.Mk, statefulSet *appsv1.StatefulSet, status *beta1.MkStatus) error {
+	status.Replicas = statefulSet.Status.Replicas
+	status.ReadyReplicas = statefulSet.Status.ReadyReplicas
+	status.Selector = labels.SelectorFromSet(statefulSet.Spec.Selector.MatchLabels).String()
+	status.Binding = &beta1.BindingStatus{Name: bindingSecretName(mkResource)}
+	// the plan is only reported while dry-run is enabled
+	status.Plan = nil
+
+	if equality.Semantic.DeepEqual(&mkResource.Status, status) {
+		return nil
+	}
+
+	// Never modify objects from the lister cache, work on a copy instead
+	mkCopy := mkResource.DeepCopy()
+	mkCopy.Status = *status
+	_, err := c.mkClient.MongokubeBeta1().Mks(mkResource.Namespace).UpdateStatus(ctx, mkCopy, metav1.UpdateOptions{})
+
+	return err
+}
+
+// Report the state of the MongoDB statefulset, if there is one, without changing anything
+func (c *Controller) updatePausedStatus(ctx context.Context, mkResource *beta1.Mk) error {
+	statefulSet, err := c.k8sclient.AppsV1().StatefulSets(mkResource.Namespace).Get(ctx, statefulSetName(mkResource), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		statefulSet = &appsv1.StatefulSet{
+			Spec: appsv1.StatefulSetSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: mongoLabels(mkResource)},
+			},
+		}
+	} else if err != nil {
+		return err
+	}
+
+	if mkResource.Status.Progress != progressPaused {
+		c.recorder.Event(mkResource, v1.EventTypeNormal, reasonPaused, "Paused reconciling")
+	}
+
+	status := mkResource.Status.DeepCopy()
+	status.Progress = progressPaused
+	return c.updateStatus(ctx, mkResource, statefulSet, status)
+}
+
+// Key/value pairs of the spec for logging, without the credentials
+func redactedSpec(mkResource *beta1.Mk) []interface{} {
+	return []interface{}{
+		"mongoDbImage", mkResource.Spec.MongoDbImage,
+		"mongoExpressImage", mkResource.Spec.MongoExpressImage,
+		"replicas", mkResource.Replicas(),
+		"paused", mkResource.Paused(),
+		"maintenance", mkResource.InMaintenance(),
+		"dbUsername", mkResource.Spec.DbUsername,
+		"dbPassword", "<redacted>",
+	}
+}
+
+// Labels of the MongoDB pods
+func mongoLabels(mkResource *beta1.Mk) map[string]string {
+	return map[string]string{"app": mkResource.Name + "db"}
 }
 
-// Create a secret for mongodb
-func (c *Controller) createSecret(mkResource *beta1.Mk) (*v1.Secret, error) {
+// Labels of the Mongo Express pods
+func mongoExpressLabels(mkResource *beta1.Mk) map[string]string {
+	return map[string]string{"app": mkResource.Name + "express"}
+}
+
+// MongoDB container in the pod template of the statefulset, nil if there is none
+func mongoContainer(mkResource *beta1.Mk, statefulSet *appsv1.StatefulSet) *v1.Container {
+	containers := statefulSet.Spec.Template.Spec.Containers
+	for i := range containers {
+		if containers[i].Name == mkResource.MongoContainerName() {
+			return &containers[i]
+		}
+	}
+	return nil
+}
+
+// Create a secret for mongodb. Other keys of an adopted secret are kept.
+func (c *Controller) createSecret(ctx context.Context, mkResource *beta1.Mk) (*v1.Secret, error) {
+	secret := buildSecret(mkResource)
+	mutate := secretMutator(secret)
+	if mkResource.Spec.Adopt != nil && mkResource.Spec.Adopt.Secret != "" {
+		mutate = secretKeysMutator(secret)
+	}
+	return createOrUpdate(ctx, c, mkResource, c.k8sclient.CoreV1().Secrets(mkResource.Namespace), secret, mutate)
+}
+
+// Secret holding the credentials of the root user
+func buildSecret(mkResource *beta1.Mk) *v1.Secret {
 	secretData := map[string][]byte{
 		"username": []byte(mkResource.Spec.DbUsername),
 		"password": []byte(mkResource.Spec.DbPassword),
@@ -213,47 +731,188 @@ func (c *Controller) createSecret(mkResource *beta1.Mk) (*v1.Secret, error) {
 
 	secret := &v1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "mongodb-secret",
+			Name:      mongoSecretName(mkResource),
 			Namespace: mkResource.Namespace,
 		},
 		Data: secretData,
 	}
-	createdSecret, err := c.k8sclient.CoreV1().Secrets(mkResource.Namespace).Create(context.Background(), secret, metav1.CreateOptions{})
 
-	return createdSecret, err
+	return secret
+}
+
+// Create the keyfile which is used by the replica set members to authenticate to each other.
+// The key is only generated if the secret does not exist and no member uses it, an existing
+// key is never replaced: members restarted with another key cannot authenticate to the others
+// anymore. A keyfile deleted while members use it is therefore reported as an error.
+func (c *Controller) createKeyfileSecret(ctx context.Context, mkResource *beta1.Mk) (*v1.Secret, error) {
+	secrets := c.k8sclient.CoreV1().Secrets(mkResource.Namespace)
+	existing, err := secrets.Get(ctx, keyfileSecretName(mkResource), metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	var key []byte
+	if err == nil {
+		key = existing.Data["keyfile"]
+	} else {
+		statefulSet, err := c.k8sclient.AppsV1().StatefulSets(mkResource.Namespace).Get(ctx, statefulSetName(mkResource), metav1.GetOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+		if err == nil && mountsSecret(statefulSet, keyfileSecretName(mkResource)) {
+			return nil, fmt.Errorf("keyfile secret %s was deleted while the members of statefulset %s use it, a new key would lock them out of the replica set; "+
+				"restore it with the key of a running member, which is in /keyfile/keyfile of its %s container",
+				keyfileSecretName(mkResource), statefulSet.Name, mkResource.MongoContainerName())
+		}
+		if key, err = generateKeyfile(); err != nil {
+			return nil, err
+		}
+	}
+
+	return createOrUpdate(ctx, c, mkResource, secrets, buildKeyfileSecret(mkResource, key), nil)
 }
 
-func (c *Controller) createMongoDeployment(mkResource *beta1.Mk, secret *v1.Secret) (*appsv1.Deployment, error) {
+// Name of the secret with the keyfile of the replica set
+func keyfileSecretName(mkResource *beta1.Mk) string {
+	return mkResource.Name + "-keyfile"
+}
+
+// Random key for the members of a replica set
+func generateKeyfile() ([]byte, error) {
+	key := make([]byte, 756)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return []byte(base64.StdEncoding.EncodeToString(key)), nil
+}
+
+// Secret holding the given keyfile
+func buildKeyfileSecret(mkResource *beta1.Mk, key []byte) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      keyfileSecretName(mkResource),
+			Namespace: mkResource.Namespace,
+		},
+		Data: map[string][]byte{
+			"keyfile": key,
+		},
+	}
+}
+
+// Whether the pods of the statefulset mount the secret
+func mountsSecret(statefulSet *appsv1.StatefulSet, name string) bool {
+	for _, volume := range statefulSet.Spec.Template.Spec.Volumes {
+		if volume.Secret != nil && volume.Secret.SecretName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Create the headless service which gives every replica set member a stable DNS name. The
+// service of an adopted statefulset keeps selecting its pods by the selector of the statefulset.
+func (c *Controller) createMongoHeadlessService(ctx context.Context, mkResource *beta1.Mk, adopted *appsv1.StatefulSet) (*v1.Service, error) {
+	service := buildMongoHeadlessService(mkResource)
+	if adopted != nil {
+		service.Spec.Selector = adopted.Spec.Selector.MatchLabels
+	}
+	return createOrUpdate(ctx, c, mkResource, c.k8sclient.CoreV1().Services(mkResource.Namespace), service, serviceMutator(service))
+}
+
+// Headless service of the replica set members
+func buildMongoHeadlessService(mkResource *beta1.Mk) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      headlessServiceName(mkResource),
+			Namespace: mkResource.Namespace,
+		},
+		Spec: v1.ServiceSpec{
+			ClusterIP: v1.ClusterIPNone,
+			Selector:  mongoLabels(mkResource),
+			// members must be able to resolve each other before they are ready
+			PublishNotReadyAddresses: true,
+			Ports: []v1.ServicePort{
+				{
+					Name: "mongodb",
+					Port: 27017,
+				},
+			},
+		},
+	}
+}
+
+// Create the statefulset running the MongoDB replica set. The number of replicas is only set
+// on creation, afterwards it is changed by reconcileReplicaSet once members have been added or removed.
+// Pods are only replaced by reconcileUpgrade, which restarts one member at a time.
+func (c *Controller) createMongoStatefulSet(ctx context.Context, mkResource *beta1.Mk, image string, secret *v1.Secret, keyfile *v1.Secret, monitoringSecret *v1.Secret, mongodConfig *v1.ConfigMap, initMarker *v1.ConfigMap, headlessService *v1.Service, adopted *appsv1.StatefulSet) (*appsv1.StatefulSet, error) {
+	statefulSet := buildMongoStatefulSet(mkResource, image, secret, keyfile, monitoringSecret, mongodConfig, initMarker, headlessService, c.options.ImageRegistry)
+	if adopted != nil {
+		adoptStatefulSet(mkResource, statefulSet, adopted, image)
+	}
+	return createOrUpdate(ctx, c, mkResource, c.k8sclient.AppsV1().StatefulSets(mkResource.Namespace), statefulSet, statefulSetMutator(statefulSet))
+}
+
+// Statefulset of the replica set members, the optional monitoringSecret, mongodConfig and
+// initMarker add the exporter sidecar, mongod.conf and the init scripts
+func buildMongoStatefulSet(mkResource *beta1.Mk, image string, secret *v1.Secret, keyfile *v1.Secret, monitoringSecret *v1.Secret, mongodConfig *v1.ConfigMap, initMarker *v1.ConfigMap, headlessService *v1.Service, imageRegistry string) *appsv1.StatefulSet {
 	// container data
 	// label to connect with service
-	replica := int32(2)
+	replica := mkResource.Replicas()
 	var containerPort int32 = 27017
 
-	deployment := &appsv1.Deployment{
+	statefulSet := &appsv1.StatefulSet{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      mkResource.Name + "-deployment",
+			Name:      statefulSetName(mkResource),
 			Namespace: mkResource.Namespace,
-			Labels:    map[string]string{"app": mkResource.Name + "db"},
+			Labels:    mongoLabels(mkResource),
 		},
-		Spec: appsv1.DeploymentSpec{
-			Replicas: &replica,
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:             &replica,
+			ServiceName:          headlessService.Name,
+			VolumeClaimTemplates: []v1.PersistentVolumeClaim{dataVolumeClaim(mkResource)},
+			UpdateStrategy: appsv1.StatefulSetUpdateStrategy{
+				Type: appsv1.OnDeleteStatefulSetStrategyType,
+			},
 			Selector: &metav1.LabelSelector{
-				MatchLabels: map[string]string{"app": mkResource.Name + "db"},
+				MatchLabels: mongoLabels(mkResource),
 			},
 			Template: v1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: map[string]string{"app": mkResource.Name + "db"},
+					Labels: mongoLabels(mkResource),
 				},
 				Spec: v1.PodSpec{
+					// mongod refuses keyfiles which are readable by others, so copy it
+					// from the secret volume and fix the permissions before starting
+					InitContainers: []v1.Container{
+						{
+							Name:    "keyfile",
+							Image:   image,
+							Command: []string{"sh", "-c", "cp /keyfile-secret/keyfile /keyfile/keyfile && chmod 400 /keyfile/keyfile && chown 999:999 /keyfile/keyfile"},
+							VolumeMounts: []v1.VolumeMount{
+								{Name: "keyfile-secret", MountPath: "/keyfile-secret"},
+								{Name: "keyfile", MountPath: "/keyfile"},
+							},
+						},
+					},
 					Containers: []v1.Container{
 						{
-							Name:  mkResource.Name + "-container",
-							Image: mkResource.Spec.MongoDbImage,
+							Name:  mkResource.MongoContainerName(),
+							Image: image,
+							Args:  []string{"--replSet", beta1.ReplicaSetName, "--bind_ip_all", "--keyFile", "/keyfile/keyfile"},
 							Ports: []v1.ContainerPort{
 								{
+									Name:          "mongodb",
 									ContainerPort: containerPort,
 								},
 							},
+							ReadinessProbe: &v1.Probe{
+								ProbeHandler: v1.ProbeHandler{
+									TCPSocket: &v1.TCPSocketAction{
+										Port: intstr.FromInt32(containerPort),
+									},
+								},
+								PeriodSeconds: 10,
+							},
 							Env: []v1.EnvVar{
 								{
 									Name: "MONGO_INITDB_ROOT_USERNAME",
@@ -262,7 +921,7 @@ func (c *Controller) createMongoDeployment(mkResource *beta1.Mk, secret *v1.Secr
 											LocalObjectReference: v1.LocalObjectReference{
 												Name: secret.Name,
 											},
-											Key: c.getKey("username", secret),
+											Key: getKey("username", secret),
 										},
 									},
 								},
@@ -273,11 +932,28 @@ func (c *Controller) createMongoDeployment(mkResource *beta1.Mk, secret *v1.Secr
 											LocalObjectReference: v1.LocalObjectReference{
 												Name: secret.Name,
 											},
-											Key: c.getKey("password", secret),
+											Key: getKey("password", secret),
 										},
 									},
 								},
 							},
+							VolumeMounts: []v1.VolumeMount{
+								{Name: "keyfile", MountPath: "/keyfile"},
+								{Name: dataVolumeName, MountPath: mongoDataDir},
+							},
+							Resources: mongoResources(mkResource),
+						},
+					},
+					Volumes: []v1.Volume{
+						{
+							Name: "keyfile-secret",
+							VolumeSource: v1.VolumeSource{
+								Secret: &v1.SecretVolumeSource{SecretName: keyfile.Name},
+							},
+						},
+						{
+							Name:         "keyfile",
+							VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
 						},
 					},
 				},
@@ -285,32 +961,68 @@ func (c *Controller) createMongoDeployment(mkResource *beta1.Mk, secret *v1.Secr
 		},
 	}
 
-	deploymentResponse, err := c.k8sclient.AppsV1().Deployments(mkResource.Namespace).Create(context.Background(), deployment, metav1.CreateOptions{})
+	podSpec := &statefulSet.Spec.Template.Spec
+	if monitoringSecret != nil {
+		podSpec.Containers = append(podSpec.Containers, exporterContainer(mkResource, monitoringSecret))
+	}
+
+	if tls := externalTLS(mkResource); tls != nil {
+		addExternalTLS(podSpec, image, tls)
+	}
+
+	// Options on the command line take precedence over mongod.conf
+	if mongodConfig != nil {
+		mongo := &podSpec.Containers[0]
+		mongo.Args = append(mongo.Args, "--config", mongodConfigDir+"/"+mongodConfigFile)
+		mongo.VolumeMounts = append(mongo.VolumeMounts, v1.VolumeMount{Name: "mongod-config", MountPath: mongodConfigDir, ReadOnly: true})
+		podSpec.Volumes = append(podSpec.Volumes, v1.Volume{
+			Name: "mongod-config",
+			VolumeSource: v1.VolumeSource{
+				ConfigMap: &v1.ConfigMapVolumeSource{LocalObjectReference: v1.LocalObjectReference{Name: mongodConfig.Name}},
+			},
+		})
+		statefulSet.Spec.Template.Annotations = map[string]string{configHashAnnotation: mongodConfigHash(mongodConfig)}
+	}
+
+	if initMarker != nil {
+		addInitScripts(mkResource, podSpec, image, initMarker)
+	}
+
+	setImageOptions(mkResource, podSpec, imageRegistry)
 
-	return deploymentResponse, err
+	return statefulSet
 }
 
 // Create mongo express deployment
-func (c *Controller) createMongoExpressDeployment(mkResource *beta1.Mk, secret *v1.Secret, mongodbService *v1.Service) (*appsv1.Deployment, error) {
+func (c *Controller) createMongoExpressDeployment(ctx context.Context, mkResource *beta1.Mk, secret *v1.Secret, mongodbService *v1.Service) (*appsv1.Deployment, error) {
+	deployment := buildMongoExpressDeployment(mkResource, secret, mongodbService, c.options.ImageRegistry)
+	return createOrUpdate(ctx, c, mkResource, c.k8sclient.AppsV1().Deployments(mkResource.Namespace), deployment, deploymentMutator(deployment))
+}
+
+// Mongo express deployment, scaled to zero during maintenance
+func buildMongoExpressDeployment(mkResource *beta1.Mk, secret *v1.Secret, mongodbService *v1.Service, imageRegistry string) *appsv1.Deployment {
 	// container data
 	// label to connect with service
 	replica := int32(2)
+	if mkResource.InMaintenance() {
+		replica = 0
+	}
 	var containerPort int32 = 8081
 
 	deployment := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      mkResource.Name + "-express-deployment",
 			Namespace: mkResource.Namespace,
-			Labels:    map[string]string{"app": mkResource.Name + "express"},
+			Labels:    mongoExpressLabels(mkResource),
 		},
 		Spec: appsv1.DeploymentSpec{
 			Replicas: &replica,
 			Selector: &metav1.LabelSelector{
-				MatchLabels: map[string]string{"app": mkResource.Name + "express"},
+				MatchLabels: mongoExpressLabels(mkResource),
 			},
 			Template: v1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: map[string]string{"app": mkResource.Name + "express"},
+					Labels: mongoExpressLabels(mkResource),
 				},
 				Spec: v1.PodSpec{
 					Containers: []v1.Container{
@@ -330,7 +1042,7 @@ func (c *Controller) createMongoExpressDeployment(mkResource *beta1.Mk, secret *
 											LocalObjectReference: v1.LocalObjectReference{
 												Name: secret.Name,
 											},
-											Key: c.getKey("username", secret),
+											Key: getKey("username", secret),
 										},
 									},
 								},
@@ -341,7 +1053,7 @@ func (c *Controller) createMongoExpressDeployment(mkResource *beta1.Mk, secret *
 											LocalObjectReference: v1.LocalObjectReference{
 												Name: secret.Name,
 											},
-											Key: c.getKey("password", secret),
+											Key: getKey("password", secret),
 										},
 									},
 								},
@@ -349,6 +1061,19 @@ func (c *Controller) createMongoExpressDeployment(mkResource *beta1.Mk, secret *
 									Name:  "ME_CONFIG_MONGODB_SERVER",
 									Value: mongodbService.Name,
 								},
+								{
+									// the connection string of the binding secret has the credentials escaped, the
+									// members it lists are only used as seeds, the driver discovers the others
+									Name: "ME_CONFIG_MONGODB_URL",
+									ValueFrom: &v1.EnvVarSource{
+										SecretKeyRef: &v1.SecretKeySelector{
+											LocalObjectReference: v1.LocalObjectReference{
+												Name: bindingSecretName(mkResource),
+											},
+											Key: "uri",
+										},
+									},
+								},
 							},
 						},
 					},
@@ -357,13 +1082,13 @@ func (c *Controller) createMongoExpressDeployment(mkResource *beta1.Mk, secret *
 		},
 	}
 
-	deploymentResponse, err := c.k8sclient.AppsV1().Deployments(mkResource.Namespace).Create(context.Background(), deployment, metav1.CreateOptions{})
+	setImageOptions(mkResource, &deployment.Spec.Template.Spec, imageRegistry)
 
-	return deploymentResponse, err
+	return deployment
 }
 
 // Get the desired key from secret
-func (c *Controller) getKey(key string, secret *v1.Secret) string {
+func getKey(key string, secret *v1.Secret) string {
 	var desiredKey string
 
 	for k := range secret.Data {
@@ -375,11 +1100,91 @@ func (c *Controller) getKey(key string, secret *v1.Secret) string {
 	return desiredKey
 }
 
+// Check that the options of spec.service fit the service types, the api server refuses
+// externalTrafficPolicy for ClusterIP services and loadBalancerSourceRanges for all but
+// LoadBalancer services
+func validateServiceSpec(spec *beta1.ServiceSpec) error {
+	if spec == nil {
+		return nil
+	}
+
+	serviceType := v1.ServiceType(spec.Type)
+	if serviceType == "" {
+		serviceType = v1.ServiceTypeClusterIP
+	}
+	if spec.ExternalTrafficPolicy != "" && !hasNodePorts(serviceType) {
+		return fmt.Errorf("service.externalTrafficPolicy cannot be set for a %s service, only for NodePort and LoadBalancer", serviceType)
+	}
+	if len(spec.LoadBalancerSourceRanges) > 0 && serviceType != v1.ServiceTypeLoadBalancer {
+		return fmt.Errorf("service.loadBalancerSourceRanges cannot be set for a %s service, only for LoadBalancer", serviceType)
+	}
+
+	if external := spec.External; external != nil {
+		if external.Type == string(v1.ServiceTypeNodePort) && len(external.LoadBalancerSourceRanges) > 0 {
+			return fmt.Errorf("service.external.loadBalancerSourceRanges cannot be set for NodePort services, only for LoadBalancer")
+		}
+		if external.Domain != "" && external.TLS == nil {
+			return fmt.Errorf("service.external.domain requires service.external.tls, members pick the horizon by the server name of TLS connections; without a domain clients connect to single members with directConnection=true")
+		}
+		if external.TLS != nil && external.TLS.SecretName == "" {
+			return fmt.Errorf("service.external.tls.secretName is required")
+		}
+	}
+	return nil
+}
+
+// Service in front of the MongoDB pods, customized through spec.service
+func mongoDbServiceConfig(mkResource *beta1.Mk, statefulSet *appsv1.StatefulSet) MongoService {
+	mongodbService := MongoService{
+		name:        "mongodb-service",
+		label:       statefulSet.Spec.Selector.MatchLabels,
+		serviceType: v1.ServiceTypeClusterIP,
+		port:        27017,
+	}
+	if spec := mkResource.Spec.Service; spec != nil {
+		if spec.Type != "" {
+			mongodbService.serviceType = v1.ServiceType(spec.Type)
+		}
+		if spec.Port != 0 && spec.Port != 27017 {
+			mongodbService.port = spec.Port
+			mongodbService.targetPort = 27017
+		}
+		mongodbService.annotations = spec.Annotations
+		mongodbService.loadBalancerSourceRanges = spec.LoadBalancerSourceRanges
+		mongodbService.externalTrafficPolicy = v1.ServiceExternalTrafficPolicy(spec.ExternalTrafficPolicy)
+	}
+	if monitoringEnabled(mkResource) {
+		mongodbService.metricsPort = exporterPort
+	}
+	return mongodbService
+}
+
+// External service in front of the Mongo Express pods. It selects the labels of the pod
+// template, the deployment itself also carries the managed-by label.
+func mongoExpressServiceConfig(mkResource *beta1.Mk) MongoService {
+	return MongoService{
+		name:        "mongoexpress-service",
+		label:       mongoExpressLabels(mkResource),
+		serviceType: v1.ServiceTypeLoadBalancer,
+		port:        8081,
+		nodePort:    31000,
+	}
+}
+
 // Create service for pods of mongodb or mongoexpress
-func (c *Controller) createMongoService(mkResource *beta1.Mk, mongoStruct MongoService) (*v1.Service, error) {
+func (c *Controller) createMongoService(ctx context.Context, mkResource *beta1.Mk, mongoStruct MongoService) (*v1.Service, error) {
+	service := buildMongoService(mkResource, mongoStruct)
+	return createOrUpdate(ctx, c, mkResource, c.k8sclient.CoreV1().Services(mkResource.Namespace), service, serviceMutator(service))
+}
+
+// Service described by mongoStruct
+func buildMongoService(mkResource *beta1.Mk, mongoStruct MongoService) *v1.Service {
 	service := &v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: mongoStruct.name,
+			Name:        mongoStruct.name,
+			Namespace:   mkResource.Namespace,
+			Labels:      mongoStruct.label,
+			Annotations: ownedAnnotations(nil, mongoStruct.annotations),
 		},
 		Spec: v1.ServiceSpec{
 			Type:     v1.ServiceType(mongoStruct.serviceType),
@@ -390,10 +1195,22 @@ func (c *Controller) createMongoService(mkResource *beta1.Mk, mongoStruct MongoS
 					NodePort: mongoStruct.nodePort,
 				},
 			},
+			LoadBalancerSourceRanges: mongoStruct.loadBalancerSourceRanges,
+			ExternalTrafficPolicy:    mongoStruct.externalTrafficPolicy,
 		},
 	}
+	if mongoStruct.targetPort != 0 {
+		service.Spec.Ports[0].TargetPort = intstr.FromInt32(mongoStruct.targetPort)
+	}
 
-	serviceCreated, err := c.k8sclient.CoreV1().Services(mkResource.Namespace).Create(context.Background(), service, metav1.CreateOptions{})
+	// services with more than one port need port names
+	if mongoStruct.metricsPort != 0 {
+		service.Spec.Ports[0].Name = "mongodb"
+		service.Spec.Ports = append(service.Spec.Ports, v1.ServicePort{
+			Name: "metrics",
+			Port: mongoStruct.metricsPort,
+		})
+	}
 
-	return serviceCreated, err
+	return service
 }
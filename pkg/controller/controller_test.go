@@ -0,0 +1,362 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+	mkfake "mongokube/pkg/client/clientset/versioned/fake"
+	mklister "mongokube/pkg/client/listers/mongokube/beta1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// fakeExecutor records the scripts evaluated through mongoEval, which are the last argument of
+// the command, and answers them with respond
+type fakeExecutor struct {
+	calls   []execCall
+	respond func(pod, script string) (string, error)
+}
+
+type execCall struct {
+	pod    string
+	script string
+}
+
+func (e *fakeExecutor) Exec(ctx context.Context, namespace, pod, container string, command []string) (string, error) {
+	script := command[len(command)-1]
+	e.calls = append(e.calls, execCall{pod: pod, script: script})
+	if e.respond == nil {
+		return "", nil
+	}
+	return e.respond(pod, script)
+}
+
+func (e *fakeExecutor) Stream(ctx context.Context, namespace, pod, container string, command []string, stdin io.Reader, stdout io.Writer) error {
+	out, err := e.Exec(ctx, namespace, pod, container, command)
+	io.WriteString(stdout, out)
+	return err
+}
+
+// Controller with fake clients, which hold the Mk resource if it is not nil and the objects
+func testController(mkResource *beta1.Mk, objects ...runtime.Object) (*Controller, *kubefake.Clientset) {
+	k8sclient := kubefake.NewSimpleClientset(objects...)
+	mkClient := mkfake.NewSimpleClientset()
+	if mkResource != nil {
+		mkClient = mkfake.NewSimpleClientset(mkResource)
+	}
+	return &Controller{
+		k8sclient: k8sclient,
+		mkClient:  mkClient,
+		executor:  &fakeExecutor{},
+		recorder:  record.NewFakeRecorder(100),
+	}, k8sclient
+}
+
+func TestValidateServiceSpec(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    *beta1.ServiceSpec
+		wantErr bool
+	}{
+		{name: "no service spec"},
+		{name: "defaults", spec: &beta1.ServiceSpec{}},
+		{name: "Local NodePort", spec: &beta1.ServiceSpec{Type: "NodePort", ExternalTrafficPolicy: "Local"}},
+		{name: "restricted LoadBalancer", spec: &beta1.ServiceSpec{Type: "LoadBalancer", ExternalTrafficPolicy: "Local", LoadBalancerSourceRanges: []string{"10.0.0.0/8"}}},
+		{name: "policy of a default service", spec: &beta1.ServiceSpec{ExternalTrafficPolicy: "Cluster"}, wantErr: true},
+		{name: "policy of a ClusterIP service", spec: &beta1.ServiceSpec{Type: "ClusterIP", ExternalTrafficPolicy: "Local"}, wantErr: true},
+		{name: "source ranges of a NodePort service", spec: &beta1.ServiceSpec{Type: "NodePort", LoadBalancerSourceRanges: []string{"10.0.0.0/8"}}, wantErr: true},
+		{
+			name: "source ranges of external LoadBalancer services",
+			spec: &beta1.ServiceSpec{External: &beta1.ExternalAccessSpec{LoadBalancerSourceRanges: []string{"10.0.0.0/8"}}},
+		},
+		{
+			name:    "source ranges of external NodePort services",
+			spec:    &beta1.ServiceSpec{External: &beta1.ExternalAccessSpec{Type: "NodePort", LoadBalancerSourceRanges: []string{"10.0.0.0/8"}}},
+			wantErr: true,
+		},
+		{
+			name: "domain with TLS",
+			spec: &beta1.ServiceSpec{External: &beta1.ExternalAccessSpec{Domain: "mongo.example.com", TLS: &beta1.ExternalTLSSpec{SecretName: "mongo-tls"}}},
+		},
+		{
+			name:    "domain without TLS",
+			spec:    &beta1.ServiceSpec{External: &beta1.ExternalAccessSpec{Domain: "mongo.example.com"}},
+			wantErr: true,
+		},
+		{
+			name:    "TLS without secret",
+			spec:    &beta1.ServiceSpec{External: &beta1.ExternalAccessSpec{TLS: &beta1.ExternalTLSSpec{}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateServiceSpec(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateServiceSpec() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// Controller without clients, which finds no Mk resource for any queued key
+func testRunController(shutdownTimeout time.Duration) *Controller {
+	return &Controller{
+		mkLister:    mklister.NewMkLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})),
+		mkSynched:   []cache.InformerSynced{func() bool { return true }},
+		mkWorkQueue: workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		recorder:    record.NewFakeRecorder(10),
+		broadcaster: record.NewBroadcaster(),
+		options:     Options{Workers: 2, ShutdownTimeout: shutdownTimeout},
+	}
+}
+
+func TestRunShutsDownQueue(t *testing.T) {
+	c := testRunController(time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	returned := make(chan struct{})
+	go func() {
+		c.Run(ctx, context.Background())
+		close(returned)
+	}()
+	c.mkWorkQueue.Add("default/a")
+	c.mkWorkQueue.Add("default/b")
+	// the workers take the items, none of them is found
+	for c.mkWorkQueue.Len() > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+
+	select {
+	case <-returned:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Run did not return after ctx was cancelled")
+	}
+	if !c.mkWorkQueue.ShuttingDown() {
+		t.Errorf("queue is not shut down")
+	}
+}
+
+func TestRunCancelledWork(t *testing.T) {
+	c := testRunController(time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	workCtx, cancelWork := context.WithCancel(context.Background())
+
+	returned := make(chan struct{})
+	go func() {
+		c.Run(ctx, workCtx)
+		close(returned)
+	}()
+	// leadership lost: work is cancelled together with ctx, nothing waits for the shutdown timeout
+	cancelWork()
+	cancel()
+
+	select {
+	case <-returned:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Run did not return after its work was cancelled")
+	}
+}
+
+func TestProcessNextItemCancelled(t *testing.T) {
+	c := testRunController(time.Minute)
+	c.mkLister = nil // never reached
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	c.mkWorkQueue.Add("default/a")
+	if !c.processNextItem(ctx) {
+		t.Fatalf("worker stopped before the queue was shut down")
+	}
+	if c.mkWorkQueue.Len() != 0 {
+		t.Errorf("cancelled item is still queued")
+	}
+}
+
+func TestCreateKeyfileSecret(t *testing.T) {
+	mkResource := testMk()
+	existing := buildKeyfileSecret(mkResource, []byte("existing key"))
+	setOwnership(mkResource, existing)
+
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		wantKey []byte
+		wantErr string
+	}{
+		{name: "new instance"},
+		{name: "existing key is kept", objects: []runtime.Object{existing}, wantKey: []byte("existing key")},
+		{
+			name:    "deleted while members use it",
+			objects: []runtime.Object{testStatefulSet(mkResource, nil, nil)},
+			wantErr: "/keyfile/keyfile of its test-container container",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, k8sclient := testController(mkResource, tt.objects...)
+
+			keyfile, err := c.createKeyfileSecret(context.Background(), mkResource)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				if _, err := k8sclient.CoreV1().Secrets("default").Get(context.Background(), "test-keyfile", metav1.GetOptions{}); err == nil {
+					t.Errorf("keyfile secret recreated")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			key := keyfile.Data["keyfile"]
+			if tt.wantKey != nil && !bytes.Equal(key, tt.wantKey) {
+				t.Errorf("key = %q, want the existing %q", key, tt.wantKey)
+			}
+			if tt.wantKey == nil && len(key) != 1008 {
+				t.Errorf("generated key has %d characters, want 1008", len(key))
+			}
+
+			// later reconciles keep the key
+			again, err := c.createKeyfileSecret(context.Background(), mkResource)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(again.Data["keyfile"], key) {
+				t.Errorf("key changed on the next reconcile")
+			}
+		})
+	}
+}
+
+func TestMountsSecret(t *testing.T) {
+	statefulSet := testStatefulSet(testMk(), nil, nil)
+	if !mountsSecret(statefulSet, "test-keyfile") {
+		t.Errorf("keyfile secret not found in the pod template")
+	}
+	if mountsSecret(statefulSet, "test-monitoring") {
+		t.Errorf("monitoring secret found in a pod template without the exporter")
+	}
+}
+
+// Actions of the fake clientset which change an object
+func writeActions(k8sclient *kubefake.Clientset) []string {
+	var writes []string
+	for _, action := range k8sclient.Actions() {
+		switch action.GetVerb() {
+		case "get", "list", "watch":
+		default:
+			writes = append(writes, action.GetVerb()+" "+action.GetResource().Resource)
+		}
+	}
+	return writes
+}
+
+func TestHandleMkResourcePaused(t *testing.T) {
+	tests := []struct {
+		name        string
+		paused      bool
+		annotations map[string]string
+	}{
+		{name: "spec field", paused: true},
+		{name: "annotation", annotations: map[string]string{beta1.PausedAnnotation: "true"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mk := testMk()
+			mk.Spec.Paused = tt.paused
+			mk.Annotations = tt.annotations
+			replicas := int32(5)
+			mk.Spec.Replicas = &replicas
+			statefulSet := testStatefulSet(mk, nil, nil)
+			statefulSet.Status.Replicas, statefulSet.Status.ReadyReplicas = 3, 2
+			c, k8sclient := testController(mk, statefulSet)
+			k8sclient.ClearActions()
+
+			if err := c.handleMkResource(context.Background(), mk); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if writes := writeActions(k8sclient); len(writes) != 0 {
+				t.Errorf("paused instance changed objects: %v", writes)
+			}
+			if calls := c.executor.(*fakeExecutor).calls; len(calls) != 0 {
+				t.Errorf("paused instance ran scripts: %v", calls)
+			}
+			got, err := c.mkClient.MongokubeBeta1().Mks("default").Get(context.Background(), "test", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status.Progress != progressPaused || got.Status.Replicas != 3 || got.Status.ReadyReplicas != 2 {
+				t.Errorf("status = %s with %d ready of %d, want %s with 2 ready of 3",
+					got.Status.Progress, got.Status.ReadyReplicas, got.Status.Replicas, progressPaused)
+			}
+			if event := nextEvent(c); !strings.Contains(event, reasonPaused) {
+				t.Errorf("event = %q, want %s", event, reasonPaused)
+			}
+		})
+	}
+}
+
+func TestHandleMkResourceMaintenance(t *testing.T) {
+	tests := []struct {
+		name        string
+		maintenance bool
+		annotations map[string]string
+	}{
+		{name: "spec field", maintenance: true},
+		{name: "annotation", annotations: map[string]string{beta1.MaintenanceAnnotation: "true"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mk := testMk()
+			mk.Spec.Maintenance = tt.maintenance
+			mk.Annotations = tt.annotations
+			c, _ := testController(mk)
+
+			if err := c.handleMkResource(context.Background(), mk); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			deployment, err := c.k8sclient.AppsV1().Deployments("default").Get(context.Background(), "test-express-deployment", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *deployment.Spec.Replicas != 0 {
+				t.Errorf("Mongo Express replicas = %d, want 0", *deployment.Spec.Replicas)
+			}
+			if calls := c.executor.(*fakeExecutor).calls; len(calls) != 0 {
+				t.Errorf("members changed during maintenance: %v", calls)
+			}
+			got, err := c.mkClient.MongokubeBeta1().Mks("default").Get(context.Background(), "test", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status.Progress != progressMaintenance {
+				t.Errorf("progress = %s, want %s", got.Status.Progress, progressMaintenance)
+			}
+			// the created objects are reported first
+			var events []string
+			for event := nextEvent(c); event != ""; event = nextEvent(c) {
+				events = append(events, event)
+			}
+			if len(events) == 0 || !strings.Contains(events[len(events)-1], reasonMaintenance) {
+				t.Errorf("events = %q, want %s last", events, reasonMaintenance)
+			}
+		})
+	}
+}
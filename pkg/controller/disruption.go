@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"context"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Name of the pod disruption budget of the MongoDB pods
+func podDisruptionBudgetName(mkResource *beta1.Mk) string {
+	return mkResource.Name + "-mongodb-pdb"
+}
+
+// Number of MongoDB pods which may be evicted at once, or for 2 members which have to stay
+// available. By default the majority of the members is kept, so that the replica set can still
+// elect a primary. 2 members have no majority to spare, so minAvailable keeps one of them
+// instead, which lets node drains proceed while the replica set has no primary.
+func budgetLimits(mkResource *beta1.Mk) (maxUnavailable *intstr.IntOrString, minAvailable *intstr.IntOrString) {
+	if mkResource.Spec.PodDisruptionBudget != nil && mkResource.Spec.PodDisruptionBudget.MaxUnavailable != nil {
+		return mkResource.Spec.PodDisruptionBudget.MaxUnavailable, nil
+	}
+
+	replicas := mkResource.Replicas()
+	if replicas == 2 {
+		one := intstr.FromInt32(1)
+		return nil, &one
+	}
+	majority := replicas/2 + 1
+	unavailable := intstr.FromInt32(replicas - majority)
+	return &unavailable, nil
+}
+
+// Create the pod disruption budget of the MongoDB pods. A single member has no majority to keep,
+// so unless maxUnavailable is set, there is no budget which would block node drains forever.
+// The budget of an adopted statefulset selects its pods by the selector of the statefulset.
+func (c *Controller) createPodDisruptionBudget(ctx context.Context, mkResource *beta1.Mk, adopted *appsv1.StatefulSet) (*policyv1.PodDisruptionBudget, error) {
+	client := c.k8sclient.PolicyV1().PodDisruptionBudgets(mkResource.Namespace)
+
+	podDisruptionBudget := buildPodDisruptionBudget(mkResource)
+	if podDisruptionBudget == nil {
+		return nil, deleteIfOwned(ctx, c, mkResource, client, podDisruptionBudgetName(mkResource))
+	}
+	if adopted != nil {
+		podDisruptionBudget.Spec.Selector.MatchLabels = adopted.Spec.Selector.MatchLabels
+	}
+
+	return createOrUpdate(ctx, c, mkResource, client, podDisruptionBudget, podDisruptionBudgetMutator(podDisruptionBudget))
+}
+
+// Pod disruption budget of the MongoDB pods, nil if there should be none
+func buildPodDisruptionBudget(mkResource *beta1.Mk) *policyv1.PodDisruptionBudget {
+	if mkResource.Replicas() == 1 && (mkResource.Spec.PodDisruptionBudget == nil || mkResource.Spec.PodDisruptionBudget.MaxUnavailable == nil) {
+		return nil
+	}
+
+	unavailable, available := budgetLimits(mkResource)
+	return &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      podDisruptionBudgetName(mkResource),
+			Namespace: mkResource.Namespace,
+		},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			MaxUnavailable: unavailable,
+			MinAvailable:   available,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: mongoLabels(mkResource),
+			},
+		},
+	}
+}
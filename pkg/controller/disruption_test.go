@@ -0,0 +1,80 @@
+package controller
+
+import (
+	"testing"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestBudgetLimits(t *testing.T) {
+	percent := intstr.FromString("50%")
+	zero := intstr.FromInt32(0)
+	tests := []struct {
+		name               string
+		replicas           int32
+		maxUnavailable     *intstr.IntOrString
+		wantMaxUnavailable *intstr.IntOrString
+		wantMinAvailable   *intstr.IntOrString
+	}{
+		{name: "single member", replicas: 1, wantMaxUnavailable: intOrString(0)},
+		{name: "two members keep one", replicas: 2, wantMinAvailable: intOrString(1)},
+		{name: "three members", replicas: 3, wantMaxUnavailable: intOrString(1)},
+		{name: "four members", replicas: 4, wantMaxUnavailable: intOrString(1)},
+		{name: "five members", replicas: 5, wantMaxUnavailable: intOrString(2)},
+		{name: "set in the spec", replicas: 3, maxUnavailable: &percent, wantMaxUnavailable: &percent},
+		{name: "two members blocking drains", replicas: 2, maxUnavailable: &zero, wantMaxUnavailable: &zero},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mkResource := testMk()
+			mkResource.Spec.Replicas = &tt.replicas
+			if tt.maxUnavailable != nil {
+				mkResource.Spec.PodDisruptionBudget = &beta1.PodDisruptionBudgetSpec{MaxUnavailable: tt.maxUnavailable}
+			}
+
+			maxUnavailable, minAvailable := budgetLimits(mkResource)
+			if !equality.Semantic.DeepEqual(maxUnavailable, tt.wantMaxUnavailable) {
+				t.Errorf("maxUnavailable = %v, want %v", maxUnavailable, tt.wantMaxUnavailable)
+			}
+			if !equality.Semantic.DeepEqual(minAvailable, tt.wantMinAvailable) {
+				t.Errorf("minAvailable = %v, want %v", minAvailable, tt.wantMinAvailable)
+			}
+		})
+	}
+}
+
+func TestBuildPodDisruptionBudget(t *testing.T) {
+	mkResource := testMk()
+	single := int32(1)
+	mkResource.Spec.Replicas = &single
+	if podDisruptionBudget := buildPodDisruptionBudget(mkResource); podDisruptionBudget != nil {
+		t.Errorf("single member got a budget: %v", podDisruptionBudget.Spec)
+	}
+
+	two := int32(2)
+	mkResource.Spec.Replicas = &two
+	podDisruptionBudget := buildPodDisruptionBudget(mkResource)
+	if podDisruptionBudget == nil || podDisruptionBudget.Spec.MaxUnavailable != nil || podDisruptionBudget.Spec.MinAvailable == nil {
+		t.Fatalf("budget of 2 members = %v, want minAvailable only", podDisruptionBudget)
+	}
+
+	// the mutator switches between the two fields
+	three := int32(3)
+	mkResource.Spec.Replicas = &three
+	desired := buildPodDisruptionBudget(mkResource)
+	if !podDisruptionBudgetMutator(desired)(podDisruptionBudget) {
+		t.Fatalf("scaling to 3 members does not update the budget")
+	}
+	if podDisruptionBudget.Spec.MinAvailable != nil || !equality.Semantic.DeepEqual(podDisruptionBudget.Spec.MaxUnavailable, intOrString(1)) {
+		t.Errorf("budget of 3 members = %v, want maxUnavailable 1", podDisruptionBudget.Spec)
+	}
+}
+
+func intOrString(value int32) *intstr.IntOrString {
+	v := intstr.FromInt32(value)
+	return &v
+}
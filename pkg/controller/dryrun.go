@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// Annotation which makes the controller only plan the changes to a Mk resource when set to "true"
+const dryRunAnnotation = "mongokube.wrd/dry-run"
+
+// dryRunPlan collects the changes a dry-run reconcile would make, in the order they would be made
+type dryRunPlan struct {
+	changes []string
+}
+
+type dryRunKey struct{}
+
+// Whether the changes to the Mk resource are only planned, through --dry-run or the dry-run annotation
+func (c *Controller) isDryRun(mkResource *beta1.Mk) bool {
+	return c.options.DryRun || mkResource.Annotations[dryRunAnnotation] == "true"
+}
+
+// Context in which createOrUpdate and deleteIfOwned record their changes in the returned plan.
+// Changes are still sent to the api server, as server-side dry-run, so that they are validated
+// and defaulted, but nothing is persisted.
+func withDryRun(ctx context.Context) (context.Context, *dryRunPlan) {
+	plan := &dryRunPlan{}
+	return context.WithValue(ctx, dryRunKey{}, plan), plan
+}
+
+// Plan of the dry-run reconcile in the context, nil if changes are applied
+func dryRunFrom(ctx context.Context) *dryRunPlan {
+	plan, _ := ctx.Value(dryRunKey{}).(*dryRunPlan)
+	return plan
+}
+
+// Record a change, e.g. add("create", "Secret", "mongodb-secret")
+func (p *dryRunPlan) add(action, kind, name string) {
+	p.changes = append(p.changes, fmt.Sprintf("%s %s %s", action, kind, name))
+}
+
+// Record the changes to the members which reconcileReplicaSet and rollMembers would make, given
+// the statefulset before and after the dry-run update: scaling the replica set, and restarting
+// members which do not run the pod template of the update. An upgrade restarts the members
+// anyway, and is planned on its own. Members are left alone during maintenance.
+func planMembers(mkResource *beta1.Mk, previous, updated *appsv1.StatefulSet, plan *dryRunPlan) {
+	if previous == nil || mkResource.InMaintenance() {
+		return
+	}
+
+	current := int32(1)
+	if previous.Spec.Replicas != nil {
+		current = *previous.Spec.Replicas
+	}
+	if desired := mkResource.Replicas(); current != desired {
+		plan.changes = append(plan.changes, fmt.Sprintf("scale %s from %d to %d members", beta1.ReplicaSetName, current, desired))
+	}
+
+	templateChanged := !equality.Semantic.DeepEqual(ownedPodTemplate(previous.Spec.Template), ownedPodTemplate(updated.Spec.Template))
+	if templateChanged || previous.Status.UpdatedReplicas < previous.Status.Replicas {
+		plan.changes = append(plan.changes, "restart members one at a time")
+	}
+}
+
+// Options of create, update and delete requests in the context
+func createOptions(ctx context.Context) metav1.CreateOptions {
+	if dryRunFrom(ctx) != nil {
+		return metav1.CreateOptions{DryRun: []string{metav1.DryRunAll}}
+	}
+	return metav1.CreateOptions{}
+}
+
+func updateOptions(ctx context.Context) metav1.UpdateOptions {
+	if dryRunFrom(ctx) != nil {
+		return metav1.UpdateOptions{DryRun: []string{metav1.DryRunAll}}
+	}
+	return metav1.UpdateOptions{}
+}
+
+func deleteOptions(ctx context.Context) metav1.DeleteOptions {
+	if dryRunFrom(ctx) != nil {
+		return metav1.DeleteOptions{DryRun: []string{metav1.DryRunAll}}
+	}
+	return metav1.DeleteOptions{}
+}
+
+// Report the plan in status.plan and, if it changed, in a Planned event. Nothing else of the
+// status is touched, as nothing was applied.
+func (c *Controller) reportPlan(ctx context.Context, mkResource *beta1.Mk, plan *dryRunPlan) error {
+	klog.FromContext(ctx).Info("Planned changes", "changes", plan.changes)
+
+	previous := mkResource.Status.Plan
+	if previous != nil && strings.Join(previous.Changes, "\n") == strings.Join(plan.changes, "\n") {
+		return nil
+	}
+
+	message := "Dry run, nothing to change"
+	if len(plan.changes) > 0 {
+		message = "Dry run, would " + strings.Join(plan.changes, ", ")
+	}
+	c.recorder.Event(mkResource, v1.EventTypeNormal, reasonPlanned, message)
+
+	// Never modify objects from the lister cache, work on a copy instead
+	mkCopy := mkResource.DeepCopy()
+	mkCopy.Status.Plan = &beta1.PlanStatus{Changes: plan.changes}
+	_, err := c.mkClient.MongokubeBeta1().Mks(mkResource.Namespace).UpdateStatus(ctx, mkCopy, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,214 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// recordingClient passes requests on to a client and records whether they were dry-run
+type recordingClient[T kubeObject] struct {
+	objectClient[T]
+	dryRun []bool
+}
+
+func isDryRunAll(dryRun []string) bool {
+	return len(dryRun) == 1 && dryRun[0] == metav1.DryRunAll
+}
+
+func (r *recordingClient[T]) Create(ctx context.Context, obj T, opts metav1.CreateOptions) (T, error) {
+	r.dryRun = append(r.dryRun, isDryRunAll(opts.DryRun))
+	return r.objectClient.Create(ctx, obj, opts)
+}
+
+func (r *recordingClient[T]) Update(ctx context.Context, obj T, opts metav1.UpdateOptions) (T, error) {
+	r.dryRun = append(r.dryRun, isDryRunAll(opts.DryRun))
+	return r.objectClient.Update(ctx, obj, opts)
+}
+
+func (r *recordingClient[T]) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	r.dryRun = append(r.dryRun, isDryRunAll(opts.DryRun))
+	return r.objectClient.Delete(ctx, name, opts)
+}
+
+// Event recorded by the fake recorder, empty if there is none
+func nextEvent(c *Controller) string {
+	select {
+	case event := <-c.recorder.(*record.FakeRecorder).Events:
+		return event
+	default:
+		return ""
+	}
+}
+
+func TestDryRunCreateOrUpdate(t *testing.T) {
+	mkResource := testMk()
+	mkResource.UID = "mk-uid"
+	owned := func(password string) *v1.Secret {
+		secret := buildMonitoringSecret(mkResource, []byte(password))
+		setOwnership(mkResource, secret)
+		return secret
+	}
+
+	tests := []struct {
+		name     string
+		existing *v1.Secret
+		wantPlan []string
+	}{
+		{name: "create", wantPlan: []string{"create Secret test-monitoring"}},
+		{name: "update", existing: owned("old"), wantPlan: []string{"update Secret test-monitoring"}},
+		{name: "unchanged", existing: owned("new")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, k8sclient := testController(mkResource)
+			if tt.existing != nil {
+				if _, err := k8sclient.CoreV1().Secrets("default").Create(context.Background(), tt.existing, metav1.CreateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			client := &recordingClient[*v1.Secret]{objectClient: k8sclient.CoreV1().Secrets("default")}
+			ctx, plan := withDryRun(context.Background())
+
+			desired := buildMonitoringSecret(mkResource, []byte("new"))
+			if _, err := createOrUpdate(ctx, c, mkResource, client, desired, secretMutator(desired)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(plan.changes, tt.wantPlan) {
+				t.Errorf("plan = %v, want %v", plan.changes, tt.wantPlan)
+			}
+			for _, dryRun := range client.dryRun {
+				if !dryRun {
+					t.Errorf("request is not sent as dry-run")
+				}
+			}
+			if len(client.dryRun) != len(tt.wantPlan) {
+				t.Errorf("sent %d requests, want %d", len(client.dryRun), len(tt.wantPlan))
+			}
+			if event := nextEvent(c); event != "" {
+				t.Errorf("dry-run recorded event %q", event)
+			}
+		})
+	}
+}
+
+func TestDryRunDelete(t *testing.T) {
+	mkResource := testMk()
+	mkResource.UID = "mk-uid"
+	secret := buildMonitoringSecret(mkResource, []byte("password"))
+	setOwnership(mkResource, secret)
+	c, k8sclient := testController(mkResource, secret)
+	client := &recordingClient[*v1.Secret]{objectClient: k8sclient.CoreV1().Secrets("default")}
+	ctx, plan := withDryRun(context.Background())
+
+	if err := deleteIfOwned(ctx, c, mkResource, client, secret.Name); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"delete Secret test-monitoring"}; !reflect.DeepEqual(plan.changes, want) {
+		t.Errorf("plan = %v, want %v", plan.changes, want)
+	}
+	if !reflect.DeepEqual(client.dryRun, []bool{true}) {
+		t.Errorf("delete is not sent as dry-run")
+	}
+	if event := nextEvent(c); event != "" {
+		t.Errorf("dry-run recorded event %q", event)
+	}
+}
+
+func TestReportPlan(t *testing.T) {
+	changes := []string{"create Secret test-monitoring", "scale rs0 from 1 to 3 members"}
+
+	tests := []struct {
+		name      string
+		previous  *beta1.PlanStatus
+		changes   []string
+		wantEvent string
+	}{
+		{name: "first plan", changes: changes, wantEvent: "Dry run, would create Secret test-monitoring, scale rs0 from 1 to 3 members"},
+		{name: "same plan", previous: &beta1.PlanStatus{Changes: changes}, changes: changes},
+		{name: "changed plan", previous: &beta1.PlanStatus{Changes: changes[:1]}, changes: changes, wantEvent: "Dry run, would create"},
+		{name: "nothing to change", previous: &beta1.PlanStatus{Changes: changes}, wantEvent: "Dry run, nothing to change"},
+		{name: "still nothing to change", previous: &beta1.PlanStatus{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mkResource := testMk()
+			mkResource.Status.Plan = tt.previous
+			c, _ := testController(mkResource)
+
+			if err := c.reportPlan(context.Background(), mkResource, &dryRunPlan{changes: tt.changes}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			event := nextEvent(c)
+			if tt.wantEvent == "" {
+				if event != "" {
+					t.Errorf("event %q for an unchanged plan", event)
+				}
+				return
+			}
+			if !strings.Contains(event, reasonPlanned) || !strings.Contains(event, tt.wantEvent) {
+				t.Errorf("event = %q, want %q", event, tt.wantEvent)
+			}
+
+			updated, err := c.mkClient.MongokubeBeta1().Mks("default").Get(context.Background(), "test", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if updated.Status.Plan == nil || !reflect.DeepEqual(updated.Status.Plan.Changes, tt.changes) {
+				t.Errorf("status.plan = %v, want %v", updated.Status.Plan, tt.changes)
+			}
+		})
+	}
+}
+
+func TestPlanMembers(t *testing.T) {
+	statefulSet := func(replicas int32, image string, updated int32) *appsv1.StatefulSet {
+		mkResource := testMk()
+		mkResource.Spec.MongoDbImage = image
+		statefulSet := testStatefulSet(mkResource, nil, nil)
+		statefulSet.Spec.Replicas = &replicas
+		statefulSet.Status.Replicas = replicas
+		statefulSet.Status.UpdatedReplicas = updated
+		return statefulSet
+	}
+
+	tests := []struct {
+		name        string
+		replicas    int32
+		maintenance bool
+		previous    *appsv1.StatefulSet
+		want        []string
+	}{
+		{name: "new statefulset", replicas: 3},
+		{name: "unchanged", replicas: 3, previous: statefulSet(3, "mongo:7.0", 3)},
+		{name: "scale up", replicas: 3, previous: statefulSet(1, "mongo:7.0", 1), want: []string{"scale rs0 from 1 to 3 members"}},
+		{name: "scale down", replicas: 1, previous: statefulSet(3, "mongo:7.0", 3), want: []string{"scale rs0 from 3 to 1 members"}},
+		{name: "changed template", replicas: 3, previous: statefulSet(3, "mongo:7.0.1", 3), want: []string{"restart members one at a time"}},
+		{name: "outdated members", replicas: 3, previous: statefulSet(3, "mongo:7.0", 2), want: []string{"restart members one at a time"}},
+		{name: "maintenance", replicas: 1, maintenance: true, previous: statefulSet(3, "mongo:7.0.1", 3)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mkResource := testMk()
+			mkResource.Spec.Replicas = &tt.replicas
+			mkResource.Spec.Maintenance = tt.maintenance
+			plan := &dryRunPlan{}
+
+			planMembers(mkResource, tt.previous, statefulSet(3, "mongo:7.0", 3), plan)
+			if !reflect.DeepEqual(plan.changes, tt.want) {
+				t.Errorf("plan = %v, want %v", plan.changes, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package controller
+
+// Reasons of the events recorded on Mk resources
+const (
+	reasonCreated      = "Created"
+	reasonUpdated      = "Updated"
+	reasonFailedCreate = "FailedCreate"
+	reasonFailedUpdate = "FailedUpdate"
+	reasonDeleted      = "Deleted"
+	reasonFailedDelete = "FailedDelete"
+
+	reasonReconcileFailed       = "ReconcileFailed"
+	reasonReplicaSetInitiated   = "ReplicaSetInitiated"
+	reasonMemberAdded           = "MemberAdded"
+	reasonMemberRemoved         = "MemberRemoved"
+	reasonPrimarySteppedDown    = "PrimarySteppedDown"
+	reasonScaled                = "Scaled"
+	reasonHorizonsConfigured    = "HorizonsConfigured"
+	reasonRunning               = "Running"
+	reasonPaused                = "Paused"
+	reasonResumed               = "Resumed"
+	reasonMaintenance           = "Maintenance"
+	reasonMonitoringUserCreated = "MonitoringUserCreated"
+	reasonMonitoringUserUpdated = "MonitoringUserUpdated"
+	reasonInvalidConfig         = "InvalidConfig"
+	reasonInitialized           = "Initialized"
+	reasonInitScriptsNotRun     = "InitScriptsNotRun"
+	reasonPlanned               = "Planned"
+	reasonAdopted               = "Adopted"
+	reasonAdoptionFailed        = "AdoptionFailed"
+	reasonMigrated              = "Migrated"
+	reasonMigrationFailed       = "MigrationFailed"
+
+	reasonUpgradeStarted          = "UpgradeStarted"
+	reasonUpgradeBlocked          = "UpgradeBlocked"
+	reasonMemberRestarted         = "MemberRestarted"
+	reasonRolloutBlocked          = "RolloutBlocked"
+	reasonFeatureCompatibilitySet = "FeatureCompatibilitySet"
+	reasonUpgraded                = "Upgraded"
+)
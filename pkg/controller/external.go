@@ -0,0 +1,159 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// Name of the horizon in the replica set config used by clients outside the cluster
+	externalHorizon = "external"
+
+	// Directory of the certificate of spec.service.external.tls in the MongoDB container
+	externalTLSDir = "/external-tls"
+)
+
+// Labels of the per member services
+func externalServiceLabels(mkResource *beta1.Mk) map[string]string {
+	return map[string]string{"app": mkResource.Name + "db-external"}
+}
+
+// Name of the service of the member with the given ordinal
+func externalServiceName(statefulSet *appsv1.StatefulSet, ordinal int32) string {
+	return statefulSetPod(statefulSet, ordinal) + "-external"
+}
+
+// Create a service for every replica set member, so that clients outside the cluster can reach
+// each of them, and remove the services of members which are gone. With a domain, the returned
+// map holds the address of every member in the external horizon, keyed by ordinal.
+func (c *Controller) createExternalServices(ctx context.Context, mkResource *beta1.Mk, statefulSet *appsv1.StatefulSet) (map[int32]string, error) {
+	client := c.k8sclient.CoreV1().Services(mkResource.Namespace)
+
+	var external *beta1.ExternalAccessSpec
+	if mkResource.Spec.Service != nil {
+		external = mkResource.Spec.Service.External
+	}
+
+	desired := int32(0)
+	if external != nil {
+		desired = mkResource.Replicas()
+	}
+
+	// Services of members which are gone, or of all members if external access is disabled
+	serviceList, err := client.List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(externalServiceLabels(mkResource)).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, service := range serviceList.Items {
+		if int32(podOrdinal(strings.TrimSuffix(service.Name, "-external"))) >= desired {
+			if err := deleteIfOwned(ctx, c, mkResource, client, service.Name); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	horizons := map[int32]string{}
+	for i := int32(0); i < desired; i++ {
+		service := buildExternalService(mkResource, statefulSet, external, i)
+		service, err := createOrUpdate(ctx, c, mkResource, client, service, serviceMutator(service))
+		if err != nil {
+			return nil, err
+		}
+
+		if external.Domain != "" {
+			// Node ports are reached on the nodes, the load balancer on the port of the service
+			port := service.Spec.Ports[0].Port
+			if service.Spec.Type == v1.ServiceTypeNodePort {
+				port = service.Spec.Ports[0].NodePort
+			}
+			horizons[i] = fmt.Sprintf("%s.%s:%d", statefulSetPod(statefulSet, i), external.Domain, port)
+		}
+	}
+
+	return horizons, nil
+}
+
+// Service of the member with the given ordinal
+func buildExternalService(mkResource *beta1.Mk, statefulSet *appsv1.StatefulSet, external *beta1.ExternalAccessSpec, ordinal int32) *v1.Service {
+	serviceType := v1.ServiceTypeLoadBalancer
+	if external.Type != "" {
+		serviceType = v1.ServiceType(external.Type)
+	}
+
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        externalServiceName(statefulSet, ordinal),
+			Namespace:   mkResource.Namespace,
+			Labels:      externalServiceLabels(mkResource),
+			Annotations: ownedAnnotations(nil, external.Annotations),
+		},
+		Spec: v1.ServiceSpec{
+			Type:     serviceType,
+			Selector: map[string]string{appsv1.StatefulSetPodNameLabel: statefulSetPod(statefulSet, ordinal)},
+			Ports: []v1.ServicePort{
+				{
+					Name: "mongodb",
+					Port: 27017,
+				},
+			},
+			LoadBalancerSourceRanges: external.LoadBalancerSourceRanges,
+		},
+	}
+}
+
+// TLS certificate of the members for external clients, nil if there is none
+func externalTLS(mkResource *beta1.Mk) *beta1.ExternalTLSSpec {
+	if mkResource.Spec.Service == nil || mkResource.Spec.Service.External == nil {
+		return nil
+	}
+	return mkResource.Spec.Service.External.TLS
+}
+
+// Serve the certificate to clients which connect with TLS. Members accept connections without TLS
+// as well and talk to each other without it, so that clients inside the cluster, the exporter and
+// the shells run by the controller are not affected. mongod wants key and certificate in one file
+// which only it can read, so they are joined by an init container like the keyfile.
+func addExternalTLS(podSpec *v1.PodSpec, image string, tls *beta1.ExternalTLSSpec) {
+	podSpec.InitContainers = append(podSpec.InitContainers, v1.Container{
+		Name:  "external-tls",
+		Image: image,
+		Command: []string{"sh", "-c", `cd /external-tls-secret
+cat tls.key tls.crt > ` + externalTLSDir + `/mongod.pem
+if [ -f ca.crt ]; then cp ca.crt ` + externalTLSDir + `/ca.crt; else cp tls.crt ` + externalTLSDir + `/ca.crt; fi
+chmod 400 ` + externalTLSDir + `/* && chown 999:999 ` + externalTLSDir + `/*`},
+		VolumeMounts: []v1.VolumeMount{
+			{Name: "external-tls-secret", MountPath: "/external-tls-secret", ReadOnly: true},
+			{Name: "external-tls", MountPath: externalTLSDir},
+		},
+	})
+
+	mongo := &podSpec.Containers[0]
+	mongo.Args = append(mongo.Args,
+		"--tlsMode", "allowTLS",
+		"--tlsCertificateKeyFile", externalTLSDir+"/mongod.pem",
+		"--tlsCAFile", externalTLSDir+"/ca.crt",
+		"--tlsAllowConnectionsWithoutCertificates",
+	)
+	mongo.VolumeMounts = append(mongo.VolumeMounts, v1.VolumeMount{Name: "external-tls", MountPath: externalTLSDir})
+
+	podSpec.Volumes = append(podSpec.Volumes,
+		v1.Volume{
+			Name:         "external-tls-secret",
+			VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: tls.SecretName}},
+		},
+		v1.Volume{
+			Name:         "external-tls",
+			VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
+		},
+	)
+}
@@ -0,0 +1,47 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+)
+
+func TestExternalTLS(t *testing.T) {
+	mkResource := testMk()
+	if statefulSet := testStatefulSet(mkResource, nil, nil); len(statefulSet.Spec.Template.Spec.InitContainers) != 1 {
+		t.Fatalf("init containers without TLS = %d, want only the keyfile", len(statefulSet.Spec.Template.Spec.InitContainers))
+	}
+
+	mkResource.Spec.Service = &beta1.ServiceSpec{External: &beta1.ExternalAccessSpec{
+		Domain: "mongo.example.com",
+		TLS:    &beta1.ExternalTLSSpec{SecretName: "mongo-tls"},
+	}}
+	podSpec := testStatefulSet(mkResource, nil, nil).Spec.Template.Spec
+
+	args := strings.Join(podSpec.Containers[0].Args, " ")
+	for _, want := range []string{"--tlsMode allowTLS", "--tlsCertificateKeyFile /external-tls/mongod.pem", "--tlsCAFile /external-tls/ca.crt", "--tlsAllowConnectionsWithoutCertificates"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("args %q do not contain %q", args, want)
+		}
+	}
+
+	if len(podSpec.InitContainers) != 2 || podSpec.InitContainers[1].Name != "external-tls" || podSpec.InitContainers[1].Image != "mongo:7.0" {
+		t.Fatalf("init containers = %+v, want keyfile and external-tls", podSpec.InitContainers)
+	}
+	secretMounted := false
+	for _, volume := range podSpec.Volumes {
+		if volume.Secret != nil && volume.Secret.SecretName == "mongo-tls" {
+			secretMounted = volume.Name == "external-tls-secret"
+		}
+	}
+	if !secretMounted {
+		t.Errorf("secret mongo-tls is not mounted into the init container")
+	}
+
+	// the pod template of an adopted statefulset is kept
+	mkResource.Spec.Adopt = &beta1.AdoptSpec{StatefulSet: "mongo", Service: "mongo-headless"}
+	if err := validateAdoptedSpec(mkResource); err == nil || !strings.Contains(err.Error(), "service.external.tls") {
+		t.Errorf("error = %v, want TLS to be refused for an adopted statefulset", err)
+	}
+}
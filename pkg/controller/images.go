@@ -0,0 +1,42 @@
+package controller
+
+import (
+	"strings"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Move an image to another registry, keeping its repository path and tag. Images without
+// a registry come from Docker Hub, where official images live under library/, e.g. with
+// the registry mirror.example.com, mongo:7.0 becomes mirror.example.com/library/mongo:7.0.
+func overrideRegistry(image, registry string) string {
+	if registry == "" {
+		return image
+	}
+
+	path := image
+	if domain, rest, found := strings.Cut(image, "/"); found && (strings.ContainsAny(domain, ".:") || domain == "localhost") {
+		path = rest
+	} else if !found {
+		path = "library/" + image
+	}
+
+	return strings.TrimSuffix(registry, "/") + "/" + path
+}
+
+// Apply the image settings of the Mk resource and the registry of the operator to every
+// container of a generated pod spec
+func setImageOptions(mkResource *beta1.Mk, podSpec *v1.PodSpec, imageRegistry string) {
+	for _, secret := range mkResource.Spec.ImagePullSecrets {
+		podSpec.ImagePullSecrets = append(podSpec.ImagePullSecrets, v1.LocalObjectReference{Name: secret.Name})
+	}
+
+	for _, containers := range [][]v1.Container{podSpec.InitContainers, podSpec.Containers} {
+		for i := range containers {
+			containers[i].Image = overrideRegistry(containers[i].Image, imageRegistry)
+			containers[i].ImagePullPolicy = v1.PullPolicy(mkResource.Spec.ImagePullPolicy)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"testing"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+func TestOverrideRegistry(t *testing.T) {
+	tests := []struct {
+		image    string
+		registry string
+		want     string
+	}{
+		{image: "mongo:7.0", want: "mongo:7.0"},
+		{image: "mongo:7.0", registry: "mirror.example.com", want: "mirror.example.com/library/mongo:7.0"},
+		{image: "mongo:7.0", registry: "mirror.example.com/", want: "mirror.example.com/library/mongo:7.0"},
+		{image: "mongo", registry: "mirror.example.com", want: "mirror.example.com/library/mongo"},
+		{image: "docker.io/library/mongo:7.0", registry: "mirror.example.com", want: "mirror.example.com/library/mongo:7.0"},
+		{image: "bitnami/mongodb:7.0", registry: "mirror.example.com", want: "mirror.example.com/bitnami/mongodb:7.0"},
+		{image: "registry.local:5000/mongo:7.0", registry: "mirror.example.com", want: "mirror.example.com/mongo:7.0"},
+		{image: "localhost/mongo:7.0", registry: "mirror.example.com", want: "mirror.example.com/mongo:7.0"},
+		{image: "mongo@sha256:0123456789abcdef", registry: "mirror.example.com", want: "mirror.example.com/library/mongo@sha256:0123456789abcdef"},
+		{image: "quay.io/org/mongo:7.0@sha256:0123456789abcdef", registry: "registry.local:5000/cache", want: "registry.local:5000/cache/org/mongo:7.0@sha256:0123456789abcdef"},
+		// moving an image twice is the same as moving it once
+		{image: "mirror.example.com/library/mongo:7.0", registry: "mirror.example.com", want: "mirror.example.com/library/mongo:7.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.image+" "+tt.registry, func(t *testing.T) {
+			if got := overrideRegistry(tt.image, tt.registry); got != tt.want {
+				t.Errorf("overrideRegistry(%q, %q) = %q, want %q", tt.image, tt.registry, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetImageOptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		registry    string
+		pullPolicy  string
+		pullSecrets []beta1.LocalObjectReference
+		wantImages  []string
+		wantSecrets []v1.LocalObjectReference
+	}{
+		{
+			name:       "defaults",
+			wantImages: []string{"mongo:7.0", "mongo:7.0", "percona/mongodb_exporter:0.40"},
+		},
+		{
+			name:        "registry, pull policy and secrets",
+			registry:    "mirror.example.com",
+			pullPolicy:  "Always",
+			pullSecrets: []beta1.LocalObjectReference{{Name: "mirror"}},
+			wantImages:  []string{"mirror.example.com/library/mongo:7.0", "mirror.example.com/library/mongo:7.0", "mirror.example.com/percona/mongodb_exporter:0.40"},
+			wantSecrets: []v1.LocalObjectReference{{Name: "mirror"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mkResource := testMk()
+			mkResource.Spec.ImagePullPolicy = tt.pullPolicy
+			mkResource.Spec.ImagePullSecrets = tt.pullSecrets
+			podSpec := &v1.PodSpec{
+				InitContainers: []v1.Container{{Name: "keyfile", Image: "mongo:7.0"}},
+				Containers:     []v1.Container{{Name: "mongo", Image: "mongo:7.0"}, {Name: "exporter", Image: "percona/mongodb_exporter:0.40"}},
+			}
+
+			setImageOptions(mkResource, podSpec, tt.registry)
+
+			var images []string
+			for _, container := range append(podSpec.InitContainers, podSpec.Containers...) {
+				images = append(images, container.Image)
+				if container.ImagePullPolicy != v1.PullPolicy(tt.pullPolicy) {
+					t.Errorf("pull policy of %s = %q, want %q", container.Name, container.ImagePullPolicy, tt.pullPolicy)
+				}
+			}
+			if !equality.Semantic.DeepEqual(images, tt.wantImages) {
+				t.Errorf("images = %v, want %v", images, tt.wantImages)
+			}
+			if !equality.Semantic.DeepEqual(podSpec.ImagePullSecrets, tt.wantSecrets) {
+				t.Errorf("pull secrets = %v, want %v", podSpec.ImagePullSecrets, tt.wantSecrets)
+			}
+		})
+	}
+}
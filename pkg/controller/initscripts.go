@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	initScriptsDir = "/docker-entrypoint-initdb.d"
+	// Mount of the scripts volume in the init container which copies them to initScriptsDir
+	initScriptsSourceDir = "/initdb-source"
+
+	// Sorted after the scripts of the user, so it only runs once they have succeeded
+	initMarkerScript = "zzzz-mongokube-initialized.js"
+
+	// Values of status.initialization
+	initializationCompleted = "Completed"
+	initializationNotRun    = "NotRun"
+)
+
+// Records that the init scripts ran in the database, where the controller can find it
+const initMarkerJS = `db.getSiblingDB("mongokube").initialization.updateOne({_id: "initScripts"}, {$set: {completedAt: new Date()}}, {upsert: true});
+`
+
+const initCheckScript = `var d = db.getSiblingDB("mongokube").initialization.findOne({_id: "initScripts"});
+print(d ? "` + initializationCompleted + `" : "` + initializationNotRun + `");`
+
+// Name of the ConfigMap holding the marker script
+func initMarkerConfigMapName(mkResource *beta1.Mk) string {
+	return mkResource.Name + "-initdb"
+}
+
+// Create the ConfigMap with the script marking the end of initialization, it is mounted
+// together with the scripts of the user. Without spec.initScripts there is none, the ConfigMap
+// is removed by deleteUnused once no member mounts it anymore.
+func (c *Controller) createInitMarkerConfigMap(ctx context.Context, mkResource *beta1.Mk) (*v1.ConfigMap, error) {
+	configMap := buildInitMarkerConfigMap(mkResource)
+	if configMap == nil {
+		return nil, nil
+	}
+
+	return createOrUpdate(ctx, c, mkResource, c.k8sclient.CoreV1().ConfigMaps(mkResource.Namespace), configMap, configMapMutator(configMap))
+}
+
+// ConfigMap with the marker script, nil without spec.initScripts
+func buildInitMarkerConfigMap(mkResource *beta1.Mk) *v1.ConfigMap {
+	if len(mkResource.Spec.InitScripts) == 0 {
+		return nil
+	}
+
+	return &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      initMarkerConfigMapName(mkResource),
+			Namespace: mkResource.Namespace,
+		},
+		Data: map[string]string{
+			initMarkerScript: initMarkerJS,
+		},
+	}
+}
+
+// Run the init scripts on the first member only. The MongoDB image runs the files in
+// initScriptsDir whenever a member starts with an empty data directory, so also on every member
+// added later, which would repeat the side effects of shell scripts. The scripts volume is
+// therefore only copied there by an init container of the pod with ordinal 0, which creates the
+// replica set; the data reaches the other members through replication.
+func addInitScripts(mkResource *beta1.Mk, podSpec *v1.PodSpec, image string, marker *v1.ConfigMap) {
+	podSpec.InitContainers = append(podSpec.InitContainers, v1.Container{
+		Name:  "init-scripts",
+		Image: image,
+		// the host name of a statefulset pod is its name, the files of the volume are symlinks
+		Command: []string{"sh", "-c", `case "$HOSTNAME" in *-0) cp -L ` + initScriptsSourceDir + `/* ` + initScriptsDir + `/ ;; esac`},
+		VolumeMounts: []v1.VolumeMount{
+			{Name: "init-scripts", MountPath: initScriptsSourceDir, ReadOnly: true},
+			{Name: "init-scripts-first-member", MountPath: initScriptsDir},
+		},
+	})
+
+	mongo := &podSpec.Containers[0]
+	mongo.VolumeMounts = append(mongo.VolumeMounts, v1.VolumeMount{Name: "init-scripts-first-member", MountPath: initScriptsDir, ReadOnly: true})
+	podSpec.Volumes = append(podSpec.Volumes,
+		initScriptsVolume(mkResource, marker),
+		v1.Volume{
+			Name:         "init-scripts-first-member",
+			VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
+		},
+	)
+}
+
+// Volume combining the ConfigMaps and Secrets of spec.initScripts with the marker script
+func initScriptsVolume(mkResource *beta1.Mk, marker *v1.ConfigMap) v1.Volume {
+	var sources []v1.VolumeProjection
+	for _, script := range mkResource.Spec.InitScripts {
+		if script.ConfigMap != nil {
+			sources = append(sources, v1.VolumeProjection{
+				ConfigMap: &v1.ConfigMapProjection{LocalObjectReference: v1.LocalObjectReference{Name: script.ConfigMap.Name}},
+			})
+		}
+		if script.Secret != nil {
+			sources = append(sources, v1.VolumeProjection{
+				Secret: &v1.SecretProjection{LocalObjectReference: v1.LocalObjectReference{Name: script.Secret.Name}},
+			})
+		}
+	}
+	sources = append(sources, v1.VolumeProjection{
+		ConfigMap: &v1.ConfigMapProjection{LocalObjectReference: v1.LocalObjectReference{Name: marker.Name}},
+	})
+
+	return v1.Volume{
+		Name: "init-scripts",
+		VolumeSource: v1.VolumeSource{
+			Projected: &v1.ProjectedVolumeSource{Sources: sources},
+		},
+	}
+}
+
+// Find out on the primary whether the init scripts ran when the replica set was created
+func (c *Controller) initialization(ctx context.Context, mkResource *beta1.Mk, primaryPod string) (string, error) {
+	out, err := c.mongoEval(ctx, mkResource, primaryPod, initCheckScript)
+	if err != nil {
+		return "", err
+	}
+
+	initialization := lastLine(out)
+	switch initialization {
+	case initializationCompleted:
+		c.recorder.Event(mkResource, v1.EventTypeNormal, reasonInitialized, "Init scripts ran when the instance was created")
+	case initializationNotRun:
+		c.recorder.Event(mkResource, v1.EventTypeWarning, reasonInitScriptsNotRun, "Init scripts did not run, they only run when the instance is created and all of them succeed")
+	default:
+		return "", fmt.Errorf("unexpected output of initialization check: %q", out)
+	}
+	return initialization, nil
+}
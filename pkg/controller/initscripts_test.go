@@ -0,0 +1,38 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+)
+
+func TestAddInitScripts(t *testing.T) {
+	mkResource := testMk()
+	mkResource.Spec.InitScripts = []beta1.InitScriptSource{{ConfigMap: &beta1.LocalObjectReference{Name: "seed"}}}
+	statefulSet := buildMongoStatefulSet(mkResource, "mongo:7.0", buildSecret(mkResource), buildKeyfileSecret(mkResource, nil), nil, nil, buildInitMarkerConfigMap(mkResource), buildMongoHeadlessService(mkResource), "")
+	podSpec := statefulSet.Spec.Template.Spec
+
+	var command string
+	for _, container := range podSpec.InitContainers {
+		if container.Name == "init-scripts" {
+			command = strings.Join(container.Command, " ")
+		}
+	}
+	if !strings.Contains(command, `case "$HOSTNAME" in *-0)`) || !strings.Contains(command, "cp -L "+initScriptsSourceDir+"/* "+initScriptsDir+"/") {
+		t.Errorf("init container command = %q, want the scripts copied on the first member only", command)
+	}
+
+	for _, mount := range podSpec.Containers[0].VolumeMounts {
+		if mount.MountPath == initScriptsDir && mount.Name != "init-scripts-first-member" {
+			t.Errorf("%s is mounted from %s, want the volume filled by the init container", initScriptsDir, mount.Name)
+		}
+	}
+	volumes := map[string]bool{}
+	for _, volume := range podSpec.Volumes {
+		volumes[volume.Name] = true
+	}
+	if !volumes["init-scripts"] || !volumes["init-scripts-first-member"] {
+		t.Errorf("volumes = %v, want init-scripts and init-scripts-first-member", volumes)
+	}
+}
@@ -0,0 +1,183 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+	"mongokube/pkg/connect"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// Whether an existing object which is not controlled by anyone may be taken over by the Mk
+// resource: an object listed in spec.adopt, which adopt has validated, or an object created by
+// mongokube releases which did not set controller references yet. Objects of the latter are
+// recognized by their name and the labels the Mk resource gave them, the secret by holding the
+// credentials of the spec, so that an object of another instance or of someone else is never
+// overwritten just because it has the same name.
+func mayTakeOver(mkResource *beta1.Mk, obj kubeObject) bool {
+	adopt := mkResource.Spec.Adopt
+	switch obj := obj.(type) {
+	case *appsv1.StatefulSet:
+		return adopt != nil && obj.Name == adopt.StatefulSet
+	case *v1.Service:
+		if adopt != nil && obj.Name == adopt.Service {
+			return true
+		}
+		switch obj.Name {
+		case "mongodb-service":
+			return equality.Semantic.DeepEqual(obj.Spec.Selector, mongoLabels(mkResource))
+		case "mongoexpress-service":
+			return equality.Semantic.DeepEqual(obj.Spec.Selector, mongoExpressLabels(mkResource))
+		}
+	case *v1.Secret:
+		if adopt != nil && adopt.Secret != "" {
+			return obj.Name == adopt.Secret
+		}
+		return obj.Name == "mongodb-secret" &&
+			string(obj.Data["username"]) == mkResource.Spec.DbUsername &&
+			string(obj.Data["password"]) == mkResource.Spec.DbPassword
+	case *appsv1.Deployment:
+		return obj.Name == mkResource.Name+"-express-deployment" && obj.Spec.Selector != nil &&
+			equality.Semantic.DeepEqual(obj.Spec.Selector.MatchLabels, mongoExpressLabels(mkResource))
+	}
+	return false
+}
+
+// Name of the Deployment which ran MongoDB in pods without a volume, before mongokube created a
+// replica set
+func legacyDeploymentName(mkResource *beta1.Mk) string {
+	return mkResource.Name + "-deployment"
+}
+
+// Whether the Deployment is the one created for the Mk resource by earlier releases
+func isLegacyDeployment(mkResource *beta1.Mk, deployment *appsv1.Deployment) bool {
+	if metav1.GetControllerOf(deployment) != nil || deployment.Spec.Selector == nil ||
+		!equality.Semantic.DeepEqual(deployment.Spec.Selector.MatchLabels, mongoLabels(mkResource)) {
+		return false
+	}
+	for _, container := range deployment.Spec.Template.Spec.Containers {
+		if container.Name == mkResource.MongoContainerName() {
+			return true
+		}
+	}
+	return false
+}
+
+// Command copying all databases but admin, config and local from the MongoDB server at host into
+// the member it runs in, as the root user. Documents which exist already are kept, so copying
+// again after a failure is safe.
+func legacyCopyCommand(host string) []string {
+	return []string{
+		"bash", "-c",
+		`set -o pipefail
+mongodump --host "$1" -u "$MONGO_INITDB_ROOT_USERNAME" -p "$MONGO_INITDB_ROOT_PASSWORD" --authenticationDatabase admin --archive |
+  mongorestore -u "$MONGO_INITDB_ROOT_USERNAME" -p "$MONGO_INITDB_ROOT_PASSWORD" --authenticationDatabase admin --archive --nsExclude 'admin.*' --nsExclude 'config.*'`,
+		"bash", host,
+	}
+}
+
+// Deployment whose databases are moved into the replica set: the one in spec.adopt, or the one
+// created by earlier releases. Nil if there is none, which is also the case once it was deleted
+// after its data has been copied.
+func (c *Controller) deploymentToMigrate(ctx context.Context, mkResource *beta1.Mk) (*appsv1.Deployment, error) {
+	name := legacyDeploymentName(mkResource)
+	if adopt := mkResource.Spec.Adopt; adopt != nil {
+		if adopt.Deployment == "" {
+			return nil, nil
+		}
+		name = adopt.Deployment
+	}
+
+	deployment, err := c.k8sclient.AppsV1().Deployments(mkResource.Namespace).Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// an adopted deployment has been checked by adopt
+	if mkResource.Spec.Adopt == nil && !isLegacyDeployment(mkResource, deployment) {
+		return nil, nil
+	}
+	return deployment, nil
+}
+
+// Move the data of the Deployment returned by deploymentToMigrate into the replica set, which
+// has to be running: the databases of each of its pods are copied into the primary, then the
+// Deployment is deleted. Its pods may keep their data in the container and not share it, so
+// every pod has to be ready and the Deployment is only deleted after all copies succeeded.
+// Returns true once there is no Deployment to migrate anymore.
+func (c *Controller) migrateDeployment(ctx context.Context, mkResource *beta1.Mk, statefulSet *appsv1.StatefulSet) (bool, error) {
+	logger := klog.FromContext(ctx)
+
+	deployment, err := c.deploymentToMigrate(ctx, mkResource)
+	if err != nil || deployment == nil {
+		return err == nil, err
+	}
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return false, err
+	}
+
+	// The pods of a legacy Deployment carry the labels of the members, they are told apart by their owner
+	podList, err := c.k8sclient.CoreV1().Pods(mkResource.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return false, err
+	}
+	var sources []*v1.Pod
+	for i, pod := range podList.Items {
+		owner := metav1.GetControllerOf(&pod)
+		if owner == nil || owner.Kind != "ReplicaSet" || !strings.HasPrefix(owner.Name, deployment.Name+"-") {
+			continue
+		}
+		if !connect.IsPodReady(&pod) {
+			logger.V(2).Info("Waiting for the pods of the deployment to be ready", "deployment", deployment.Name, "pod", pod.Name)
+			return false, nil
+		}
+		sources = append(sources, &podList.Items[i])
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].Name < sources[j].Name })
+
+	if len(sources) > 0 {
+		primaryPod, err := c.primaryPod(ctx, mkResource, statefulSet)
+		if err != nil || primaryPod == "" {
+			return false, err
+		}
+
+		for _, source := range sources {
+			logger.Info("Copying databases of the deployment", "pod", source.Name, "primary", primaryPod)
+			if _, err := c.executor.Exec(ctx, mkResource.Namespace, primaryPod, mkResource.MongoContainerName(), legacyCopyCommand(source.Status.PodIP)); err != nil {
+				c.recorder.Eventf(mkResource, v1.EventTypeWarning, reasonMigrationFailed, "Failed to copy the databases of pod %s of Deployment %s into the replica set: %v", source.Name, deployment.Name, err)
+				return false, err
+			}
+		}
+	}
+
+	propagation := metav1.DeletePropagationBackground
+	if err := c.k8sclient.AppsV1().Deployments(mkResource.Namespace).Delete(ctx, deployment.Name, metav1.DeleteOptions{PropagationPolicy: &propagation}); err != nil && !errors.IsNotFound(err) {
+		c.recorder.Eventf(mkResource, v1.EventTypeWarning, reasonFailedDelete, "Failed to delete Deployment %s: %v", deployment.Name, err)
+		return false, err
+	}
+	logger.Info("Migrated deployment", "deployment", deployment.Name)
+	c.recorder.Eventf(mkResource, v1.EventTypeNormal, reasonMigrated, "Copied the databases of %d pods of Deployment %s into replica set %s and deleted it", len(sources), deployment.Name, beta1.ReplicaSetName)
+	return true, nil
+}
+
+// Describe the migration of the Deployment in a dry-run plan
+func (c *Controller) planMigration(ctx context.Context, mkResource *beta1.Mk, plan *dryRunPlan) error {
+	deployment, err := c.deploymentToMigrate(ctx, mkResource)
+	if err != nil || deployment == nil {
+		return err
+	}
+	plan.changes = append(plan.changes, fmt.Sprintf("copy databases of the pods of Deployment %s into replica set %s and delete it", deployment.Name, beta1.ReplicaSetName))
+	return nil
+}
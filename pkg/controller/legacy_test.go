@@ -0,0 +1,189 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestIsLegacyDeployment(t *testing.T) {
+	mkResource := testMk()
+	deployment := func(labels map[string]string, container string) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: legacyDeploymentName(mkResource), Namespace: "default"},
+			Spec: appsv1.DeploymentSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: labels},
+				Template: v1.PodTemplateSpec{Spec: v1.PodSpec{Containers: []v1.Container{{Name: container, Image: "mongo:4.4.6"}}}},
+			},
+		}
+	}
+	controlled := deployment(mongoLabels(mkResource), mkResource.MongoContainerName())
+	controlled.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(mkResource, appsv1.SchemeGroupVersion.WithKind("Deployment"))}
+
+	tests := []struct {
+		name       string
+		deployment *appsv1.Deployment
+		want       bool
+	}{
+		{"created by an earlier release", deployment(mongoLabels(mkResource), mkResource.MongoContainerName()), true},
+		{"other pods", deployment(map[string]string{"app": "otherdb"}, mkResource.MongoContainerName()), false},
+		{"other container", deployment(mongoLabels(mkResource), "mongo"), false},
+		{"controlled", controlled, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isLegacyDeployment(mkResource, tt.deployment); got != tt.want {
+				t.Errorf("isLegacyDeployment() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrateLegacyDeployment(t *testing.T) {
+	mkResource := testMk()
+	statefulSet := testStatefulSet(mkResource, nil, nil)
+	primary := memberHost(statefulSet, 0)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: legacyDeploymentName(mkResource), Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: mongoLabels(mkResource)},
+			Template: v1.PodTemplateSpec{Spec: v1.PodSpec{Containers: []v1.Container{{Name: mkResource.MongoContainerName()}}}},
+		},
+	}
+	controller := true
+	legacyPod := func(name, ip string, ready bool) *v1.Pod {
+		status := v1.ConditionFalse
+		if ready {
+			status = v1.ConditionTrue
+		}
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Namespace:       "default",
+				Labels:          mongoLabels(mkResource),
+				OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: deployment.Name + "-5d9f", Controller: &controller}},
+			},
+			Status: v1.PodStatus{PodIP: ip, Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: status}}},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		pods      []runtime.Object
+		copyErr   error
+		want      bool
+		wantHosts []string
+		wantKept  bool
+	}{
+		{
+			name:      "every pod is copied",
+			pods:      []runtime.Object{legacyPod("test-deployment-5d9f-b", "10.0.0.2", true), legacyPod("test-deployment-5d9f-a", "10.0.0.1", true)},
+			want:      true,
+			wantHosts: []string{"10.0.0.1", "10.0.0.2"},
+		},
+		{
+			name:     "waits for all pods",
+			pods:     []runtime.Object{legacyPod("test-deployment-5d9f-a", "10.0.0.1", true), legacyPod("test-deployment-5d9f-b", "10.0.0.2", false)},
+			wantKept: true,
+		},
+		{
+			name:      "failed copy",
+			pods:      []runtime.Object{legacyPod("test-deployment-5d9f-a", "10.0.0.1", true), legacyPod("test-deployment-5d9f-b", "10.0.0.2", true)},
+			copyErr:   errors.New("connection refused"),
+			wantHosts: []string{"10.0.0.1"},
+			wantKept:  true,
+		},
+		{
+			name: "no pods",
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, k8sclient := testController(mkResource, append(tt.pods, deployment)...)
+			var hosts []string
+			c.executor = &fakeExecutor{respond: func(pod, script string) (string, error) {
+				if script == replicaSetStatusScript {
+					return fmt.Sprintf(`{"initialized": true, "primary": %q, "members": [%q]}`, primary, primary), nil
+				}
+				hosts = append(hosts, script)
+				return "", tt.copyErr
+			}}
+
+			done, err := c.migrateDeployment(context.Background(), mkResource, statefulSet)
+			if (err != nil) != (tt.copyErr != nil) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if done != tt.want {
+				t.Errorf("migrateDeployment() = %v, want %v", done, tt.want)
+			}
+			if !reflect.DeepEqual(hosts, tt.wantHosts) {
+				t.Errorf("copied from %v, want %v", hosts, tt.wantHosts)
+			}
+			_, err = k8sclient.AppsV1().Deployments("default").Get(context.Background(), deployment.Name, metav1.GetOptions{})
+			if kept := err == nil; kept != tt.wantKept {
+				t.Errorf("deployment kept = %v, want %v", kept, tt.wantKept)
+			}
+		})
+	}
+}
+
+func TestMigrateAdoptedDeployment(t *testing.T) {
+	mkResource := testMk()
+	mkResource.Spec.Adopt = &beta1.AdoptSpec{Deployment: "mongo"}
+	statefulSet := testStatefulSet(mkResource, nil, nil)
+	primary := memberHost(statefulSet, 0)
+	controller := true
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "mongo", Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{Selector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "app", Operator: metav1.LabelSelectorOpIn, Values: []string{"mongo"}},
+		}}},
+	}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "mongo-7c9d-x",
+			Namespace:       "default",
+			Labels:          map[string]string{"app": "mongo"},
+			OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "mongo-7c9d", Controller: &controller}},
+		},
+		Status: v1.PodStatus{PodIP: "10.0.0.3", Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionTrue}}},
+	}
+
+	c, k8sclient := testController(mkResource, deployment, pod)
+	var hosts []string
+	c.executor = &fakeExecutor{respond: func(pod, script string) (string, error) {
+		if script == replicaSetStatusScript {
+			return fmt.Sprintf(`{"initialized": true, "primary": %q, "members": [%q]}`, primary, primary), nil
+		}
+		hosts = append(hosts, script)
+		return "", nil
+	}}
+
+	done, err := c.migrateDeployment(context.Background(), mkResource, statefulSet)
+	if err != nil || !done {
+		t.Fatalf("migrateDeployment() = %v, %v, want done", done, err)
+	}
+	if !reflect.DeepEqual(hosts, []string{"10.0.0.3"}) {
+		t.Errorf("copied from %v, want the pod of the deployment", hosts)
+	}
+	if _, err := k8sclient.AppsV1().Deployments("default").Get(context.Background(), "mongo", metav1.GetOptions{}); err == nil {
+		t.Errorf("deployment is not deleted")
+	}
+
+	// once deleted there is nothing left to migrate
+	if done, err := c.migrateDeployment(context.Background(), mkResource, statefulSet); err != nil || !done {
+		t.Errorf("migrateDeployment() = %v, %v after the deployment was deleted, want done", done, err)
+	}
+}
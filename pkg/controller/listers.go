@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"mongokube/pkg/apis/mongokube/beta1"
+	mklister "mongokube/pkg/client/listers/mongokube/beta1"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// multiNamespaceMkLister combines the listers of informers which each watch a single
+// namespace. The key is the namespace, an empty key holds a lister for all namespaces.
+type multiNamespaceMkLister map[string]mklister.MkLister
+
+func (l multiNamespaceMkLister) List(selector labels.Selector) ([]*beta1.Mk, error) {
+	var mks []*beta1.Mk
+	for _, lister := range l {
+		list, err := lister.List(selector)
+		if err != nil {
+			return nil, err
+		}
+		mks = append(mks, list...)
+	}
+	return mks, nil
+}
+
+func (l multiNamespaceMkLister) Mks(namespace string) mklister.MkNamespaceLister {
+	if lister, ok := l[namespace]; ok {
+		return lister.Mks(namespace)
+	}
+	if lister, ok := l[""]; ok {
+		return lister.Mks(namespace)
+	}
+	return unwatchedNamespaceLister{}
+}
+
+// unwatchedNamespaceLister is returned for namespaces which are not watched, it never finds anything
+type unwatchedNamespaceLister struct{}
+
+func (unwatchedNamespaceLister) List(selector labels.Selector) ([]*beta1.Mk, error) {
+	return nil, nil
+}
+
+func (unwatchedNamespaceLister) Get(name string) (*beta1.Mk, error) {
+	return nil, errors.NewNotFound(beta1.Resource("mks"), name)
+}
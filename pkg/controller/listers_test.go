@@ -0,0 +1,130 @@
+package controller
+
+import (
+	"sort"
+	"testing"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+	mklister "mongokube/pkg/client/listers/mongokube/beta1"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Lister of an informer cache which holds the Mk resources
+func testMkLister(t *testing.T, mks ...*beta1.Mk) mklister.MkLister {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, mk := range mks {
+		if err := indexer.Add(mk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return mklister.NewMkLister(indexer)
+}
+
+func labeledMk(namespace, name, team string) *beta1.Mk {
+	return &beta1.Mk{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: map[string]string{"team": team}}}
+}
+
+func TestMultiNamespaceMkLister(t *testing.T) {
+	lister := multiNamespaceMkLister{
+		"a": testMkLister(t, labeledMk("a", "payments", "payments"), labeledMk("a", "search", "search")),
+		"b": testMkLister(t, labeledMk("b", "payments", "payments")),
+	}
+
+	tests := []struct {
+		name     string
+		selector string
+		want     []string
+	}{
+		{name: "everything", want: []string{"a/payments", "a/search", "b/payments"}},
+		{name: "selected in every namespace", selector: "team=payments", want: []string{"a/payments", "b/payments"}},
+		{name: "nothing selected", selector: "team=billing"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector, err := labels.Parse(tt.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mks, err := lister.List(selector)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var got []string
+			for _, mk := range mks {
+				got = append(got, mk.Namespace+"/"+mk.Name)
+			}
+			sort.Strings(got)
+			if len(got) != len(tt.want) {
+				t.Fatalf("List() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("List() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMultiNamespaceMkListerGet(t *testing.T) {
+	tests := []struct {
+		name      string
+		lister    multiNamespaceMkLister
+		namespace string
+		wantFound bool
+	}{
+		{
+			name:      "watched namespace",
+			lister:    multiNamespaceMkLister{"a": testMkLister(t), "b": testMkLister(t, labeledMk("b", "test", "payments"))},
+			namespace: "b",
+			wantFound: true,
+		},
+		{
+			name:      "other watched namespace",
+			lister:    multiNamespaceMkLister{"a": testMkLister(t), "b": testMkLister(t, labeledMk("b", "test", "payments"))},
+			namespace: "a",
+		},
+		{
+			name:      "unwatched namespace",
+			lister:    multiNamespaceMkLister{"a": testMkLister(t, labeledMk("a", "test", "payments"))},
+			namespace: "c",
+		},
+		{
+			name:      "all namespaces",
+			lister:    multiNamespaceMkLister{"": testMkLister(t, labeledMk("a", "test", "payments"), labeledMk("c", "test", "payments"))},
+			namespace: "c",
+			wantFound: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mk, err := tt.lister.Mks(tt.namespace).Get("test")
+			if !tt.wantFound {
+				if !errors.IsNotFound(err) {
+					t.Errorf("Get() error = %v, want not found", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if mk.Namespace != tt.namespace {
+				t.Errorf("Get() found %s/%s, want %s/test", mk.Namespace, mk.Name, tt.namespace)
+			}
+
+			list, err := tt.lister.Mks(tt.namespace).List(labels.Everything())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(list) != 1 {
+				t.Errorf("List() found %d Mk resources in %s, want 1", len(list), tt.namespace)
+			}
+		})
+	}
+}
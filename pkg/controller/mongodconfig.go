@@ -0,0 +1,175 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	mongodConfigFile = "mongod.conf"
+	mongodConfigDir  = "/etc/mongod"
+
+	// Hash of mongod.conf on the pod template, so that a changed configuration is rolled out
+	configHashAnnotation = "mongokube.wrd/config-hash"
+)
+
+// Options of mongod.conf which are set by the controller on the command line, and the data
+// directory, which has to be the one on the volume of the member
+var forbiddenMongodOptions = []string{
+	"net.port",
+	"net.bindIp",
+	"net.bindIpAll",
+	"replication.replSetName",
+	"security.keyFile",
+	"storage.dbPath",
+}
+
+// Name of the ConfigMap mounted as mongod.conf
+func mongodConfigMapName(mkResource *beta1.Mk) string {
+	return mkResource.Name + "-mongod-config"
+}
+
+// Read mongod.conf from spec.mongodConfig, either inline or from the referenced ConfigMap
+func (c *Controller) mongodConfig(ctx context.Context, mkResource *beta1.Mk) (string, error) {
+	config := mkResource.Spec.MongodConfig
+	if config.ConfigMapRef == nil {
+		return config.Inline, nil
+	}
+
+	configMap, err := c.k8sclient.CoreV1().ConfigMaps(mkResource.Namespace).Get(ctx, config.ConfigMapRef.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	key := config.ConfigMapRef.Key
+	if key == "" {
+		key = mongodConfigFile
+	}
+	content, ok := configMap.Data[key]
+	if !ok {
+		return "", fmt.Errorf("ConfigMap %s has no key %s", configMap.Name, key)
+	}
+	return content, nil
+}
+
+// Check that mongod.conf is valid YAML and does not set any option owned by the controller
+func validateMongodConfig(config string) error {
+	options := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(config), &options); err != nil {
+		return fmt.Errorf("mongod.conf is not valid YAML: %w", err)
+	}
+
+	for _, option := range forbiddenMongodOptions {
+		section, value := interface{}(options), true
+		for _, key := range strings.Split(option, ".") {
+			m, ok := section.(map[string]interface{})
+			if !ok {
+				value = false
+				break
+			}
+			if section, ok = m[key]; !ok {
+				value = false
+				break
+			}
+		}
+		if value {
+			return fmt.Errorf("mongod.conf may not set %s, it is managed by mongokube", option)
+		}
+	}
+
+	return nil
+}
+
+// Create the ConfigMap holding the validated mongod.conf. A referenced ConfigMap is copied, so
+// that the pods only see configurations which passed validation. Without spec.mongodConfig there
+// is none, the ConfigMap is removed by deleteUnused once no member mounts it anymore.
+func (c *Controller) createMongodConfigMap(ctx context.Context, mkResource *beta1.Mk) (*v1.ConfigMap, error) {
+	client := c.k8sclient.CoreV1().ConfigMaps(mkResource.Namespace)
+
+	if mkResource.Spec.MongodConfig == nil {
+		return nil, nil
+	}
+
+	config, err := c.mongodConfig(ctx, mkResource)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateMongodConfig(config); err != nil {
+		c.recorder.Event(mkResource, v1.EventTypeWarning, reasonInvalidConfig, err.Error())
+		return nil, err
+	}
+
+	configMap := buildMongodConfigMap(mkResource, config)
+	return createOrUpdate(ctx, c, mkResource, client, configMap, configMapMutator(configMap))
+}
+
+// ConfigMap mounted as mongod.conf with the given content
+func buildMongodConfigMap(mkResource *beta1.Mk, config string) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      mongodConfigMapName(mkResource),
+			Namespace: mkResource.Namespace,
+		},
+		Data: map[string]string{
+			mongodConfigFile: config,
+		},
+	}
+}
+
+// Hash of mongod.conf, set as annotation on the pod template
+func mongodConfigHash(configMap *v1.ConfigMap) string {
+	hash := sha256.Sum256([]byte(configMap.Data[mongodConfigFile]))
+	return hex.EncodeToString(hash[:])
+}
+
+// Event handlers for ConfigMaps, which are watched by their metadata only
+func (c *Controller) configMapHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: c.handleConfigMap,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if oldObj.(metav1.Object).GetResourceVersion() == newObj.(metav1.Object).GetResourceVersion() {
+				return
+			}
+			c.handleConfigMap(newObj)
+		},
+		DeleteFunc: c.handleConfigMap,
+	}
+}
+
+// Enqueue the Mk resources reading mongod.conf from the ConfigMap, so that a changed configuration
+// is rolled out right away, and the Mk resource controlling it, if any
+func (c *Controller) handleConfigMap(obj interface{}) {
+	c.handleOwnedObject(obj)
+
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	object, ok := obj.(metav1.Object)
+	if !ok {
+		return
+	}
+
+	mkResources, err := c.mkLister.Mks(object.GetNamespace()).List(labels.Everything())
+	if err != nil {
+		return
+	}
+	for _, mkResource := range mkResources {
+		config := mkResource.Spec.MongodConfig
+		if config != nil && config.ConfigMapRef != nil && config.ConfigMapRef.Name == object.GetName() {
+			c.logger.V(4).Info("Referenced ConfigMap changed", "configMap", klog.KObj(object), "mk", klog.KObj(mkResource))
+			c.enqueue(mkResource)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"testing"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+	mklister "mongokube/pkg/client/listers/mongokube/beta1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+func TestValidateMongodConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{"empty", "", false},
+		{"cache size", "storage:\n  wiredTiger:\n    engineConfig:\n      cacheSizeGB: 1\n", false},
+		{"other net options", "net:\n  maxIncomingConnections: 100\n", false},
+		{"port", "net:\n  port: 27018\n", true},
+		{"replica set name", "replication:\n  replSetName: rs1\n", true},
+		{"keyfile", "security:\n  keyFile: /tmp/key\n", true},
+		{"data directory", "storage:\n  dbPath: /tmp/db\n", true},
+		{"other storage options", "storage:\n  directoryPerDB: true\n", false},
+		{"invalid YAML", "storage: [", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateMongodConfig(tt.config); (err != nil) != tt.wantErr {
+				t.Errorf("validateMongodConfig() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHandleConfigMap(t *testing.T) {
+	referencing := testMk()
+	referencing.Name = "referencing"
+	referencing.Spec.MongodConfig = &beta1.MongodConfigSpec{ConfigMapRef: &beta1.ConfigMapKeyReference{Name: "tuning"}}
+	owning := testMk()
+	owning.Name = "owning"
+	owning.UID = types.UID("owning-uid")
+	other := testMk()
+	other.Name = "other"
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, mkResource := range []*beta1.Mk{referencing, owning, other} {
+		if err := indexer.Add(mkResource); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		configMap *metav1.PartialObjectMetadata
+		want      []string
+	}{
+		{
+			name:      "referenced",
+			configMap: &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "tuning", Namespace: "default"}},
+			want:      []string{"default/referencing"},
+		},
+		{
+			name: "owned",
+			configMap: &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{
+				Name:            mongodConfigMapName(owning),
+				Namespace:       "default",
+				OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(owning, beta1.SchemeGroupVersion.WithKind("Mk"))},
+			}},
+			want: []string{"default/owning"},
+		},
+		{
+			name:      "unrelated",
+			configMap: &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "kube-root-ca.crt", Namespace: "default"}},
+		},
+		{
+			name:      "other namespace",
+			configMap: &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "tuning", Namespace: "other"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Controller{
+				mkLister:    mklister.NewMkLister(indexer),
+				mkWorkQueue: workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+				logger:      klog.Background(),
+			}
+			defer c.mkWorkQueue.ShutDown()
+
+			c.handleConfigMap(cache.DeletedFinalStateUnknown{Key: tt.configMap.Namespace + "/" + tt.configMap.Name, Obj: tt.configMap})
+
+			var got []string
+			for c.mkWorkQueue.Len() > 0 {
+				key, _ := c.mkWorkQueue.Get()
+				got = append(got, key.(string))
+				c.mkWorkQueue.Done(key)
+			}
+			if len(got) != len(tt.want) || (len(got) > 0 && got[0] != tt.want[0]) {
+				t.Errorf("enqueued %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
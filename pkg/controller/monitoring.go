@@ -0,0 +1,321 @@
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog/v2"
+)
+
+const (
+	defaultExporterImage = "percona/mongodb_exporter:0.40.0"
+	exporterPort         = 9216
+	monitoringUsername   = "mongodb-exporter"
+)
+
+// ServiceMonitor of the Prometheus operator, only created if its CRD is installed
+var serviceMonitorResource = schema.GroupVersionResource{Group: "monitoring.coreos.com", Version: "v1", Resource: "servicemonitors"}
+
+// Whether the exporter sidecar is requested through spec.monitoring
+func monitoringEnabled(mkResource *beta1.Mk) bool {
+	return mkResource.Spec.Monitoring != nil
+}
+
+// Image of the exporter sidecar
+func exporterImage(mkResource *beta1.Mk) string {
+	if mkResource.Spec.Monitoring.ExporterImage != "" {
+		return mkResource.Spec.Monitoring.ExporterImage
+	}
+	return defaultExporterImage
+}
+
+// Create the credentials of the monitoring user. The password is generated once and never
+// updated afterwards. Without spec.monitoring there is none, the secret is removed by
+// deleteUnused once no member runs the exporter anymore.
+func (c *Controller) createMonitoringSecret(ctx context.Context, mkResource *beta1.Mk) (*v1.Secret, error) {
+	if !monitoringEnabled(mkResource) {
+		return nil, nil
+	}
+
+	secrets := c.k8sclient.CoreV1().Secrets(mkResource.Namespace)
+	existing, err := secrets.Get(ctx, monitoringSecretName(mkResource), metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	var password []byte
+	if err == nil {
+		password = existing.Data["password"]
+	} else if password, err = generateMonitoringPassword(); err != nil {
+		return nil, err
+	}
+
+	return createOrUpdate(ctx, c, mkResource, secrets, buildMonitoringSecret(mkResource, password), nil)
+}
+
+// Name of the secret with the credentials of the monitoring user
+func monitoringSecretName(mkResource *beta1.Mk) string {
+	return mkResource.Name + "-monitoring"
+}
+
+// Random password for the monitoring user
+func generateMonitoringPassword() ([]byte, error) {
+	password := make([]byte, 24)
+	if _, err := rand.Read(password); err != nil {
+		return nil, err
+	}
+	return []byte(hex.EncodeToString(password)), nil
+}
+
+// Secret with the credentials of the monitoring user
+func buildMonitoringSecret(mkResource *beta1.Mk, password []byte) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      monitoringSecretName(mkResource),
+			Namespace: mkResource.Namespace,
+		},
+		Data: map[string][]byte{
+			"username": []byte(monitoringUsername),
+			"password": password,
+		},
+	}
+}
+
+// Sidecar exporting the metrics of the MongoDB container next to it, authenticated as the monitoring user
+func exporterContainer(mkResource *beta1.Mk, monitoringSecret *v1.Secret) v1.Container {
+	secretEnv := func(name, key string) v1.EnvVar {
+		return v1.EnvVar{
+			Name: name,
+			ValueFrom: &v1.EnvVarSource{
+				SecretKeyRef: &v1.SecretKeySelector{
+					LocalObjectReference: v1.LocalObjectReference{Name: monitoringSecret.Name},
+					Key:                  key,
+				},
+			},
+		}
+	}
+
+	return v1.Container{
+		Name:  "mongodb-exporter",
+		Image: exporterImage(mkResource),
+		Args: []string{
+			"--mongodb.direct-connect",
+			"--compatible-mode",
+			fmt.Sprintf("--web.listen-address=:%d", exporterPort),
+		},
+		Env: []v1.EnvVar{
+			secretEnv("MONGODB_USER", "username"),
+			secretEnv("MONGODB_PASSWORD", "password"),
+			{Name: "MONGODB_URI", Value: "mongodb://localhost:27017/admin"},
+		},
+		Ports: []v1.ContainerPort{
+			{
+				Name:          "metrics",
+				ContainerPort: exporterPort,
+			},
+		},
+		Resources: v1.ResourceRequirements{Requests: exporterRequests.DeepCopy()},
+		ReadinessProbe: &v1.Probe{
+			ProbeHandler: v1.ProbeHandler{
+				TCPSocket: &v1.TCPSocketAction{
+					Port: intstr.FromInt32(exporterPort),
+				},
+			},
+			PeriodSeconds: 10,
+		},
+	}
+}
+
+// Create the monitoring user on the primary unless it exists. It may only read the
+// statistics of the server and the replica set, and the oplog for the replication lag.
+// An existing user gets the password of the secret if it cannot log in with it, e.g. because
+// the secret was recreated after monitoring had been turned off.
+func (c *Controller) createMonitoringUser(ctx context.Context, mkResource *beta1.Mk, primaryPod string, monitoringSecret *v1.Secret) error {
+	username := string(monitoringSecret.Data["username"])
+	out, err := c.mongoEval(ctx, mkResource, primaryPod, monitoringUserScript(username, string(monitoringSecret.Data["password"])))
+	if err != nil {
+		return err
+	}
+
+	switch lastLine(out) {
+	case "created":
+		klog.FromContext(ctx).Info("Created monitoring user", "user", username)
+		c.recorder.Eventf(mkResource, v1.EventTypeNormal, reasonMonitoringUserCreated, "Created monitoring user %s", username)
+	case "updated":
+		klog.FromContext(ctx).Info("Updated password of monitoring user", "user", username)
+		c.recorder.Eventf(mkResource, v1.EventTypeNormal, reasonMonitoringUserUpdated, "Set the password of monitoring user %s to the one in secret %s", username, monitoringSecret.Name)
+	}
+	return nil
+}
+
+// Script creating the monitoring user, or setting its password if it cannot log in with it.
+// The login is tried on a new connection, mongosh throws if it fails, the legacy shell returns 0.
+func monitoringUserScript(username, password string) string {
+	return fmt.Sprintf(`var admin = db.getSiblingDB("admin");
+var username = %q, password = %q;
+if (!admin.getUser(username)) {
+  admin.createUser({user: username, pwd: password, roles: [{role: "clusterMonitor", db: "admin"}, {role: "read", db: "local"}]});
+  print("created");
+} else {
+  var loggedIn = false;
+  try {
+    var r = new Mongo("localhost:27017").getDB("admin").auth(username, password);
+    loggedIn = r === 1 || r === true || (r && r.ok === 1);
+  } catch (e) {}
+  if (!loggedIn) {
+    admin.updateUser(username, {pwd: password});
+    print("updated");
+  }
+}`, username, password)
+}
+
+// unstructuredClient adapts a dynamic client to objectClient, so that custom resources of other
+// operators are managed like the built-in objects
+type unstructuredClient struct {
+	dynamic.ResourceInterface
+}
+
+func (u unstructuredClient) Get(ctx context.Context, name string, opts metav1.GetOptions) (*unstructured.Unstructured, error) {
+	return u.ResourceInterface.Get(ctx, name, opts)
+}
+
+func (u unstructuredClient) Create(ctx context.Context, obj *unstructured.Unstructured, opts metav1.CreateOptions) (*unstructured.Unstructured, error) {
+	return u.ResourceInterface.Create(ctx, obj, opts)
+}
+
+func (u unstructuredClient) Update(ctx context.Context, obj *unstructured.Unstructured, opts metav1.UpdateOptions) (*unstructured.Unstructured, error) {
+	return u.ResourceInterface.Update(ctx, obj, opts)
+}
+
+func (u unstructuredClient) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	return u.ResourceInterface.Delete(ctx, name, opts)
+}
+
+// How long the answers of API discovery are kept, a CRD installed later on is noticed afterwards
+const discoveryTTL = 5 * time.Minute
+
+// Answers of API discovery by resource, shared by all workers
+type discoveryCache struct {
+	mu      sync.Mutex
+	entries map[schema.GroupVersionResource]discoveryEntry
+}
+
+type discoveryEntry struct {
+	served    bool
+	checkedAt time.Time
+}
+
+// Whether the api server serves the given resource, e.g. because its CRD is installed. The
+// answer is cached for discoveryTTL, so that discovery is not called in every reconcile.
+func (c *Controller) resourceServed(resource schema.GroupVersionResource) (bool, error) {
+	c.discovery.mu.Lock()
+	defer c.discovery.mu.Unlock()
+
+	if entry, ok := c.discovery.entries[resource]; ok && time.Since(entry.checkedAt) < discoveryTTL {
+		return entry.served, nil
+	}
+
+	served, err := c.discoverResource(resource)
+	if err != nil {
+		return false, err
+	}
+	if c.discovery.entries == nil {
+		c.discovery.entries = map[schema.GroupVersionResource]discoveryEntry{}
+	}
+	c.discovery.entries[resource] = discoveryEntry{served: served, checkedAt: time.Now()}
+	return served, nil
+}
+
+func (c *Controller) discoverResource(resource schema.GroupVersionResource) (bool, error) {
+	resources, err := c.k8sclient.Discovery().ServerResourcesForGroupVersion(resource.GroupVersion().String())
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	for _, apiResource := range resources.APIResources {
+		if apiResource.Name == resource.Resource {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Create the ServiceMonitor which makes the Prometheus operator scrape the exporters through
+// the MongoDB service. Nothing is done if the ServiceMonitor CRD is not installed.
+func (c *Controller) createServiceMonitor(ctx context.Context, mkResource *beta1.Mk, mongoDbService *v1.Service) error {
+	served, err := c.resourceServed(serviceMonitorResource)
+	if err != nil || !served {
+		if err == nil && serviceMonitorEnabled(mkResource) {
+			klog.FromContext(ctx).V(2).Info("Not creating ServiceMonitor, its CRD is not installed")
+		}
+		return err
+	}
+
+	client := unstructuredClient{c.dynamicClient.Resource(serviceMonitorResource).Namespace(mkResource.Namespace)}
+
+	if !serviceMonitorEnabled(mkResource) {
+		return deleteIfOwned[*unstructured.Unstructured](ctx, c, mkResource, client, serviceMonitorName(mkResource))
+	}
+
+	serviceMonitor := buildServiceMonitor(mkResource, mongoDbService)
+	_, err = createOrUpdate[*unstructured.Unstructured](ctx, c, mkResource, client, serviceMonitor, func(existing *unstructured.Unstructured) bool {
+		if equality.Semantic.DeepEqual(serviceMonitor.Object["spec"], existing.Object["spec"]) {
+			return false
+		}
+		existing.Object["spec"] = serviceMonitor.Object["spec"]
+		return true
+	})
+	return err
+}
+
+// Whether a ServiceMonitor is requested through spec.monitoring
+func serviceMonitorEnabled(mkResource *beta1.Mk) bool {
+	return monitoringEnabled(mkResource) && mkResource.Spec.Monitoring.ServiceMonitor
+}
+
+// Name of the ServiceMonitor of the MongoDB service
+func serviceMonitorName(mkResource *beta1.Mk) string {
+	return mkResource.Name + "-mongodb"
+}
+
+// ServiceMonitor scraping the metrics port of the MongoDB service
+func buildServiceMonitor(mkResource *beta1.Mk, mongoDbService *v1.Service) *unstructured.Unstructured {
+	matchLabels := map[string]interface{}{}
+	for k, v := range mongoDbService.Labels {
+		matchLabels[k] = v
+	}
+
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": serviceMonitorResource.GroupVersion().String(),
+		"kind":       "ServiceMonitor",
+		"metadata": map[string]interface{}{
+			"name":      serviceMonitorName(mkResource),
+			"namespace": mkResource.Namespace,
+		},
+		"spec": map[string]interface{}{
+			"selector": map[string]interface{}{
+				"matchLabels": matchLabels,
+			},
+			"endpoints": []interface{}{
+				map[string]interface{}{"port": "metrics"},
+			},
+		},
+	}}
+}
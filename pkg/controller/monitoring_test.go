@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+
+	"k8s.io/client-go/tools/record"
+)
+
+func TestCreateMonitoringSecret(t *testing.T) {
+	mkResource := testMk()
+	c, _ := testController(mkResource)
+
+	secret, err := c.createMonitoringSecret(context.Background(), mkResource)
+	if err != nil || secret != nil {
+		t.Fatalf("createMonitoringSecret() = %v, %v without monitoring, want no secret", secret, err)
+	}
+
+	mkResource.Spec.Monitoring = &beta1.MonitoringSpec{}
+	secret, err = c.createMonitoringSecret(context.Background(), mkResource)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	password := secret.Data["password"]
+	if len(password) != 48 || string(secret.Data["username"]) != monitoringUsername {
+		t.Errorf("credentials = %s/%s, want %s with a generated password", secret.Data["username"], password, monitoringUsername)
+	}
+
+	again, err := c.createMonitoringSecret(context.Background(), mkResource)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(again.Data["password"], password) {
+		t.Errorf("password changed on the next reconcile")
+	}
+}
+
+func TestCreateMonitoringUser(t *testing.T) {
+	mkResource := testMk()
+	mkResource.Spec.Monitoring = &beta1.MonitoringSpec{}
+	secret := buildMonitoringSecret(mkResource, []byte("new-password"))
+
+	tests := []struct {
+		name      string
+		out       string
+		wantEvent string
+	}{
+		{name: "user created", out: "created\n", wantEvent: reasonMonitoringUserCreated},
+		{name: "password set", out: "updated\n", wantEvent: reasonMonitoringUserUpdated},
+		{name: "user can log in", out: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := testController(mkResource)
+			executor := &fakeExecutor{respond: func(pod, script string) (string, error) { return tt.out, nil }}
+			c.executor = executor
+
+			if err := c.createMonitoringUser(context.Background(), mkResource, "test-mongodb-1", secret); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(executor.calls) != 1 || executor.calls[0].pod != "test-mongodb-1" {
+				t.Fatalf("scripts = %v, want one on the primary", executor.calls)
+			}
+			script := executor.calls[0].script
+			for _, want := range []string{`"mongodb-exporter"`, `"new-password"`, "admin.createUser(", "admin.updateUser(username, {pwd: password})"} {
+				if !strings.Contains(script, want) {
+					t.Errorf("script does not contain %s:\n%s", want, script)
+				}
+			}
+
+			events := c.recorder.(*record.FakeRecorder).Events
+			select {
+			case event := <-events:
+				if tt.wantEvent == "" || !strings.Contains(event, tt.wantEvent) {
+					t.Errorf("event = %q, want %q", event, tt.wantEvent)
+				}
+			default:
+				if tt.wantEvent != "" {
+					t.Errorf("no event, want %q", tt.wantEvent)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"context"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Name of the network policy isolating the MongoDB pods
+func networkPolicyName(mkResource *beta1.Mk) string {
+	return mkResource.Name + "-mongodb"
+}
+
+// Create the network policy which only lets the allowed clients, Mongo Express and the other
+// replica set members connect to the MongoDB pods. Without spec.networkPolicy it is removed.
+// The pods of an adopted statefulset are selected by the selector of the statefulset.
+func (c *Controller) createNetworkPolicy(ctx context.Context, mkResource *beta1.Mk, adopted *appsv1.StatefulSet) (*networkingv1.NetworkPolicy, error) {
+	client := c.k8sclient.NetworkingV1().NetworkPolicies(mkResource.Namespace)
+
+	networkPolicy := buildNetworkPolicy(mkResource)
+	if networkPolicy == nil {
+		return nil, deleteIfOwned(ctx, c, mkResource, client, networkPolicyName(mkResource))
+	}
+	if adopted != nil {
+		members := adopted.Spec.Selector.MatchLabels
+		networkPolicy.Spec.PodSelector.MatchLabels = members
+		networkPolicy.Spec.Ingress[0].From[0].PodSelector.MatchLabels = members
+	}
+
+	return createOrUpdate(ctx, c, mkResource, client, networkPolicy, networkPolicyMutator(networkPolicy))
+}
+
+// Network policy of the MongoDB pods, nil without spec.networkPolicy
+func buildNetworkPolicy(mkResource *beta1.Mk) *networkingv1.NetworkPolicy {
+	if mkResource.Spec.NetworkPolicy == nil {
+		return nil
+	}
+
+	peers := []networkingv1.NetworkPolicyPeer{
+		// replica set members replicate from each other
+		{PodSelector: &metav1.LabelSelector{MatchLabels: mongoLabels(mkResource)}},
+		{PodSelector: &metav1.LabelSelector{MatchLabels: mongoExpressLabels(mkResource)}},
+	}
+	for _, allowed := range mkResource.Spec.NetworkPolicy.AllowedClients {
+		peers = append(peers, networkingv1.NetworkPolicyPeer{
+			NamespaceSelector: allowed.NamespaceSelector,
+			PodSelector:       allowed.PodSelector,
+		})
+	}
+
+	tcp := v1.ProtocolTCP
+	mongoDbPort := intstr.FromInt32(27017)
+	ingress := []networkingv1.NetworkPolicyIngressRule{
+		{
+			Ports: []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &mongoDbPort}},
+			From:  peers,
+		},
+	}
+
+	// Prometheus may run anywhere, the metrics of the exporter are open to all
+	if monitoringEnabled(mkResource) {
+		metricsPort := intstr.FromInt32(exporterPort)
+		ingress = append(ingress, networkingv1.NetworkPolicyIngressRule{
+			Ports: []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &metricsPort}},
+		})
+	}
+
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      networkPolicyName(mkResource),
+			Namespace: mkResource.Namespace,
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: mongoLabels(mkResource)},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress:     ingress,
+		},
+	}
+}
@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"testing"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	ordersClient    = beta1.NetworkPolicyPeer{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "orders"}}}
+	reportingClient = beta1.NetworkPolicyPeer{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"kubernetes.io/metadata.name": "reporting"}}}
+)
+
+func TestBuildNetworkPolicy(t *testing.T) {
+	tests := []struct {
+		name          string
+		networkPolicy *beta1.NetworkPolicySpec
+		monitoring    *beta1.MonitoringSpec
+		wantPeers     []networkingv1.NetworkPolicyPeer
+		wantRules     int
+	}{
+		{
+			name: "disabled",
+		},
+		{
+			name:          "no clients",
+			networkPolicy: &beta1.NetworkPolicySpec{},
+			wantRules:     1,
+		},
+		{
+			name:          "allowed clients",
+			networkPolicy: &beta1.NetworkPolicySpec{AllowedClients: []beta1.NetworkPolicyPeer{ordersClient, reportingClient}},
+			wantPeers: []networkingv1.NetworkPolicyPeer{
+				{PodSelector: ordersClient.PodSelector},
+				{NamespaceSelector: reportingClient.NamespaceSelector},
+			},
+			wantRules: 1,
+		},
+		{
+			name:          "metrics open to all",
+			networkPolicy: &beta1.NetworkPolicySpec{},
+			monitoring:    &beta1.MonitoringSpec{},
+			wantRules:     2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mkResource := testMk()
+			mkResource.Spec.NetworkPolicy = tt.networkPolicy
+			mkResource.Spec.Monitoring = tt.monitoring
+
+			networkPolicy := buildNetworkPolicy(mkResource)
+			if tt.networkPolicy == nil {
+				if networkPolicy != nil {
+					t.Fatalf("network policy built without spec.networkPolicy")
+				}
+				return
+			}
+
+			ingress := networkPolicy.Spec.Ingress
+			if len(ingress) != tt.wantRules {
+				t.Fatalf("got %d ingress rules, want %d", len(ingress), tt.wantRules)
+			}
+			// the members and Mongo Express come first
+			want := append([]networkingv1.NetworkPolicyPeer{
+				{PodSelector: &metav1.LabelSelector{MatchLabels: mongoLabels(mkResource)}},
+				{PodSelector: &metav1.LabelSelector{MatchLabels: mongoExpressLabels(mkResource)}},
+			}, tt.wantPeers...)
+			if !equality.Semantic.DeepEqual(ingress[0].From, want) {
+				t.Errorf("peers = %v, want %v", ingress[0].From, want)
+			}
+			if len(ingress) > 1 && len(ingress[1].From) != 0 {
+				t.Errorf("metrics port is restricted to %v", ingress[1].From)
+			}
+		})
+	}
+}
+
+func TestNetworkPolicyMutator(t *testing.T) {
+	mkResource := testMk()
+	mkResource.Spec.NetworkPolicy = &beta1.NetworkPolicySpec{AllowedClients: []beta1.NetworkPolicyPeer{ordersClient, reportingClient}}
+	existing := buildNetworkPolicy(mkResource)
+
+	if networkPolicyMutator(buildNetworkPolicy(mkResource))(existing) {
+		t.Errorf("unchanged network policy is updated")
+	}
+
+	// removing a client revokes its access
+	mkResource.Spec.NetworkPolicy.AllowedClients = []beta1.NetworkPolicyPeer{ordersClient}
+	desired := buildNetworkPolicy(mkResource)
+	if !networkPolicyMutator(desired)(existing) {
+		t.Fatalf("removed client is not noticed")
+	}
+	for _, peer := range existing.Spec.Ingress[0].From {
+		if peer.NamespaceSelector != nil {
+			t.Errorf("removed client is still allowed: %v", peer)
+		}
+	}
+	if !equality.Semantic.DeepEqual(existing.Spec, desired.Spec) {
+		t.Errorf("spec = %v, want %v", existing.Spec, desired.Spec)
+	}
+}
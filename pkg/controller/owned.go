@@ -0,0 +1,420 @@
+package controller
+
+import (
+	"sort"
+	"strings"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Label set on every object created for a Mk resource, informers of owned objects only watch these
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "mongokube"
+)
+
+// SelectOwnedObjects restricts the informers of owned objects to those created by the controller,
+// e.g. informers.WithTweakListOptions(controller.SelectOwnedObjects)
+func SelectOwnedObjects(options *metav1.ListOptions) {
+	options.LabelSelector = labels.SelectorFromSet(labels.Set{managedByLabel: managedByValue}).String()
+}
+
+// Event handlers for objects owned by Mk resources
+func (c *Controller) ownedObjectHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: c.handleOwnedObject,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			// periodic resyncs send updates without changes
+			if oldObj.(metav1.Object).GetResourceVersion() == newObj.(metav1.Object).GetResourceVersion() {
+				return
+			}
+			c.handleOwnedObject(newObj)
+		},
+		DeleteFunc: c.handleOwnedObject,
+	}
+}
+
+// Enqueue the Mk resource controlling the object, so that drift is corrected,
+// e.g. when a service has been deleted or the express deployment scaled by hand
+func (c *Controller) handleOwnedObject(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	object, ok := obj.(metav1.Object)
+	if !ok {
+		return
+	}
+
+	ownerReference := metav1.GetControllerOf(object)
+	if ownerReference == nil || ownerReference.Kind != "Mk" || ownerReference.APIVersion != beta1.SchemeGroupVersion.String() {
+		return
+	}
+
+	mkResource, err := c.mkLister.Mks(object.GetNamespace()).Get(ownerReference.Name)
+	if err != nil || mkResource.UID != ownerReference.UID {
+		// the owner is gone, the object is garbage collected
+		return
+	}
+
+	c.logger.V(4).Info("Owned object changed", "object", klog.KObj(object), "mk", klog.KObj(mkResource))
+	c.enqueue(mkResource)
+}
+
+// Keep the data of a secret in line with the desired data
+func secretMutator(desired *v1.Secret) func(existing *v1.Secret) bool {
+	return func(existing *v1.Secret) bool {
+		if equality.Semantic.DeepEqual(desired.Data, existing.Data) {
+			return false
+		}
+		existing.Data = desired.Data
+		return true
+	}
+}
+
+// Keep the keys of the desired data in a secret in line with it, other keys are left alone
+func secretKeysMutator(desired *v1.Secret) func(existing *v1.Secret) bool {
+	return func(existing *v1.Secret) bool {
+		changed := false
+		for k, v := range desired.Data {
+			if string(existing.Data[k]) != string(v) {
+				if existing.Data == nil {
+					existing.Data = map[string][]byte{}
+				}
+				existing.Data[k] = v
+				changed = true
+			}
+		}
+		return changed
+	}
+}
+
+// Keep the data of a ConfigMap in line with the desired data
+func configMapMutator(desired *v1.ConfigMap) func(existing *v1.ConfigMap) bool {
+	return func(existing *v1.ConfigMap) bool {
+		if equality.Semantic.DeepEqual(desired.Data, existing.Data) {
+			return false
+		}
+		existing.Data = desired.Data
+		return true
+	}
+}
+
+// Keep labels, annotations and the spec of a service in line with the desired service, fields
+// allocated by the api server like the cluster IP and node ports are left alone
+func serviceMutator(desired *v1.Service) func(existing *v1.Service) bool {
+	return func(existing *v1.Service) bool {
+		// labels are merged, others may have added their own. Annotations set by the controller
+		// are recorded, so that those removed from the spec are removed from the service as well.
+		labels := mergeMaps(existing.Labels, desired.Labels)
+		annotations := ownedAnnotations(existing.Annotations, desired.Annotations)
+
+		// allocated node ports are kept, unless the service has none anymore, e.g. as ClusterIP
+		ports := make([]v1.ServicePort, len(desired.Spec.Ports))
+		copy(ports, desired.Spec.Ports)
+		for i := range ports {
+			if ports[i].NodePort == 0 && i < len(existing.Spec.Ports) && hasNodePorts(desired.Spec.Type) {
+				ports[i].NodePort = existing.Spec.Ports[i].NodePort
+			}
+		}
+
+		spec := ownedServiceSpec(desired.Spec)
+		spec.Ports = ownedServicePorts(ports)
+		if equality.Semantic.DeepEqual(spec, ownedServiceSpec(existing.Spec)) &&
+			equality.Semantic.DeepEqual(labels, existing.Labels) &&
+			equality.Semantic.DeepEqual(annotations, existing.Annotations) {
+			return false
+		}
+
+		existing.Labels = labels
+		existing.Annotations = annotations
+		existing.Spec.Type = desired.Spec.Type
+		existing.Spec.Selector = desired.Spec.Selector
+		existing.Spec.Ports = ports
+		existing.Spec.PublishNotReadyAddresses = desired.Spec.PublishNotReadyAddresses
+		existing.Spec.LoadBalancerSourceRanges = desired.Spec.LoadBalancerSourceRanges
+		existing.Spec.ExternalTrafficPolicy = desired.Spec.ExternalTrafficPolicy
+		// the health check port is only allocated for LoadBalancer services with the Local policy
+		if desired.Spec.Type != v1.ServiceTypeLoadBalancer || desired.Spec.ExternalTrafficPolicy != v1.ServiceExternalTrafficPolicyLocal {
+			existing.Spec.HealthCheckNodePort = 0
+		}
+		return true
+	}
+}
+
+// Whether services of the type get node ports allocated
+func hasNodePorts(serviceType v1.ServiceType) bool {
+	return serviceType == v1.ServiceTypeNodePort || serviceType == v1.ServiceTypeLoadBalancer
+}
+
+// Fields of a service spec set by the controller, with the defaults of the api server filled in
+func ownedServiceSpec(spec v1.ServiceSpec) v1.ServiceSpec {
+	owned := v1.ServiceSpec{
+		Type:                     spec.Type,
+		Selector:                 spec.Selector,
+		Ports:                    ownedServicePorts(spec.Ports),
+		PublishNotReadyAddresses: spec.PublishNotReadyAddresses,
+		LoadBalancerSourceRanges: spec.LoadBalancerSourceRanges,
+		ExternalTrafficPolicy:    spec.ExternalTrafficPolicy,
+	}
+	if owned.Type == "" {
+		owned.Type = v1.ServiceTypeClusterIP
+	}
+	if owned.ExternalTrafficPolicy == "" && hasNodePorts(owned.Type) {
+		owned.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyCluster
+	}
+	return owned
+}
+
+func ownedServicePorts(ports []v1.ServicePort) []v1.ServicePort {
+	owned := make([]v1.ServicePort, len(ports))
+	for i, port := range ports {
+		owned[i] = v1.ServicePort{
+			Name:       port.Name,
+			Protocol:   port.Protocol,
+			Port:       port.Port,
+			TargetPort: port.TargetPort,
+			NodePort:   port.NodePort,
+		}
+		if owned[i].Protocol == "" {
+			owned[i].Protocol = v1.ProtocolTCP
+		}
+		if owned[i].TargetPort.IntVal == 0 && owned[i].TargetPort.StrVal == "" {
+			owned[i].TargetPort = intstr.FromInt32(port.Port)
+		}
+	}
+	return owned
+}
+
+// Annotation listing the annotations set by the controller, comma separated
+const ownedAnnotationsAnnotation = "mongokube.wrd/owned-annotations"
+
+// Copy of existing with the desired annotations added or replaced, and those which were set by the
+// controller before but are no longer desired removed. Annotations of others are kept.
+func ownedAnnotations(existing, desired map[string]string) map[string]string {
+	annotations := map[string]string{}
+	for k, v := range existing {
+		annotations[k] = v
+	}
+	for _, k := range strings.Split(existing[ownedAnnotationsAnnotation], ",") {
+		delete(annotations, k)
+	}
+	delete(annotations, ownedAnnotationsAnnotation)
+
+	owned := make([]string, 0, len(desired))
+	for k, v := range desired {
+		if k == ownedAnnotationsAnnotation {
+			continue
+		}
+		annotations[k] = v
+		owned = append(owned, k)
+	}
+	if len(owned) > 0 {
+		sort.Strings(owned)
+		annotations[ownedAnnotationsAnnotation] = strings.Join(owned, ",")
+	}
+
+	if len(annotations) == 0 {
+		return nil
+	}
+	return annotations
+}
+
+// Copy of existing with the entries of desired added or replaced
+func mergeMaps(existing, desired map[string]string) map[string]string {
+	if len(existing) == 0 && len(desired) == 0 {
+		return existing
+	}
+
+	merged := map[string]string{}
+	for k, v := range existing {
+		merged[k] = v
+	}
+	for k, v := range desired {
+		merged[k] = v
+	}
+	return merged
+}
+
+// Keep replicas and pod template of a deployment in line with the desired deployment
+func deploymentMutator(desired *appsv1.Deployment) func(existing *appsv1.Deployment) bool {
+	return func(existing *appsv1.Deployment) bool {
+		if equality.Semantic.DeepEqual(desired.Spec.Replicas, existing.Spec.Replicas) &&
+			equality.Semantic.DeepEqual(ownedPodTemplate(desired.Spec.Template), ownedPodTemplate(existing.Spec.Template)) {
+			return false
+		}
+		existing.Spec.Replicas = desired.Spec.Replicas
+		existing.Spec.Template = desired.Spec.Template
+		return true
+	}
+}
+
+// Keep the pod template of a statefulset in line with the desired statefulset. The number
+// of replicas is left to reconcileReplicaSet, which changes it one member at a time. Volume
+// claim templates cannot be changed, claims the existing statefulset lacks are not mounted.
+func statefulSetMutator(desired *appsv1.StatefulSet) func(existing *appsv1.StatefulSet) bool {
+	return func(existing *appsv1.StatefulSet) bool {
+		changed := false
+		if existing.Spec.UpdateStrategy.Type != desired.Spec.UpdateStrategy.Type {
+			existing.Spec.UpdateStrategy = desired.Spec.UpdateStrategy
+			changed = true
+		}
+		template := withoutMissingClaims(desired.Spec.Template, existing.Spec.VolumeClaimTemplates)
+		if !equality.Semantic.DeepEqual(ownedPodTemplate(template), ownedPodTemplate(existing.Spec.Template)) {
+			existing.Spec.Template = template
+			changed = true
+		}
+		return changed
+	}
+}
+
+// Prefix of the annotations set by the controller on pod templates. Others, like the one set by
+// kubectl rollout restart, are not compared.
+const annotationPrefix = "mongokube.wrd/"
+
+// Fields of a pod template which are set by the builders. Fields defaulted by the api server are
+// left out or filled in with their defaults, so that a template read from the api server equals
+// a newly built one, and anything the builders no longer set, like a removed container, argument
+// or volume, is noticed.
+func ownedPodTemplate(template v1.PodTemplateSpec) v1.PodTemplateSpec {
+	annotations := map[string]string{}
+	for k, v := range template.Annotations {
+		if strings.HasPrefix(k, annotationPrefix) {
+			annotations[k] = v
+		}
+	}
+
+	owned := v1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      template.Labels,
+			Annotations: annotations,
+		},
+		Spec: v1.PodSpec{
+			InitContainers:   make([]v1.Container, len(template.Spec.InitContainers)),
+			Containers:       make([]v1.Container, len(template.Spec.Containers)),
+			Volumes:          make([]v1.Volume, len(template.Spec.Volumes)),
+			ImagePullSecrets: template.Spec.ImagePullSecrets,
+		},
+	}
+	for i, container := range template.Spec.InitContainers {
+		owned.Spec.InitContainers[i] = ownedContainer(container)
+	}
+	for i, container := range template.Spec.Containers {
+		owned.Spec.Containers[i] = ownedContainer(container)
+	}
+	for i, volume := range template.Spec.Volumes {
+		owned.Spec.Volumes[i] = ownedVolume(volume)
+	}
+	return owned
+}
+
+func ownedContainer(container v1.Container) v1.Container {
+	owned := v1.Container{
+		Name:            container.Name,
+		Image:           container.Image,
+		Command:         container.Command,
+		Args:            container.Args,
+		Env:             container.Env,
+		Ports:           make([]v1.ContainerPort, len(container.Ports)),
+		VolumeMounts:    container.VolumeMounts,
+		Resources:       *container.Resources.DeepCopy(),
+		ImagePullPolicy: container.ImagePullPolicy,
+	}
+	// requests default to the limits
+	for name, limit := range owned.Resources.Limits {
+		if _, ok := owned.Resources.Requests[name]; !ok {
+			if owned.Resources.Requests == nil {
+				owned.Resources.Requests = v1.ResourceList{}
+			}
+			owned.Resources.Requests[name] = limit
+		}
+	}
+	for i, port := range container.Ports {
+		owned.Ports[i] = v1.ContainerPort{Name: port.Name, ContainerPort: port.ContainerPort, Protocol: port.Protocol}
+		if owned.Ports[i].Protocol == "" {
+			owned.Ports[i].Protocol = v1.ProtocolTCP
+		}
+	}
+	if owned.ImagePullPolicy == "" {
+		owned.ImagePullPolicy = defaultPullPolicy(container.Image)
+	}
+
+	if probe := container.ReadinessProbe; probe != nil {
+		owned.ReadinessProbe = &v1.Probe{
+			ProbeHandler:        probe.ProbeHandler,
+			InitialDelaySeconds: probe.InitialDelaySeconds,
+			TimeoutSeconds:      defaultInt32(probe.TimeoutSeconds, 1),
+			PeriodSeconds:       defaultInt32(probe.PeriodSeconds, 10),
+			SuccessThreshold:    defaultInt32(probe.SuccessThreshold, 1),
+			FailureThreshold:    defaultInt32(probe.FailureThreshold, 3),
+		}
+	}
+	return owned
+}
+
+func ownedVolume(volume v1.Volume) v1.Volume {
+	owned := *volume.DeepCopy()
+	defaultMode := v1.SecretVolumeSourceDefaultMode
+	switch {
+	case owned.Secret != nil && owned.Secret.DefaultMode == nil:
+		owned.Secret.DefaultMode = &defaultMode
+	case owned.ConfigMap != nil && owned.ConfigMap.DefaultMode == nil:
+		owned.ConfigMap.DefaultMode = &defaultMode
+	case owned.Projected != nil && owned.Projected.DefaultMode == nil:
+		owned.Projected.DefaultMode = &defaultMode
+	}
+	return owned
+}
+
+// Pull policy the api server sets if a container has none: images without a tag or with the
+// latest tag are always pulled
+func defaultPullPolicy(image string) v1.PullPolicy {
+	if strings.Contains(image, "@") {
+		return v1.PullIfNotPresent
+	}
+	name := image[strings.LastIndex(image, "/")+1:]
+	if _, tag, found := strings.Cut(name, ":"); found && tag != "latest" {
+		return v1.PullIfNotPresent
+	}
+	return v1.PullAlways
+}
+
+func defaultInt32(value, defaultValue int32) int32 {
+	if value == 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// Keep the spec of a pod disruption budget in line with the desired one
+func podDisruptionBudgetMutator(desired *policyv1.PodDisruptionBudget) func(existing *policyv1.PodDisruptionBudget) bool {
+	return func(existing *policyv1.PodDisruptionBudget) bool {
+		if equality.Semantic.DeepEqual(desired.Spec, existing.Spec) {
+			return false
+		}
+		existing.Spec = desired.Spec
+		return true
+	}
+}
+
+// Keep the spec of a network policy in line with the desired one
+func networkPolicyMutator(desired *networkingv1.NetworkPolicy) func(existing *networkingv1.NetworkPolicy) bool {
+	return func(existing *networkingv1.NetworkPolicy) bool {
+		if equality.Semantic.DeepEqual(desired.Spec, existing.Spec) {
+			return false
+		}
+		existing.Spec = desired.Spec
+		return true
+	}
+}
@@ -0,0 +1,418 @@
+package controller
+
+import (
+	"testing"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func testMk() *beta1.Mk {
+	return &beta1.Mk{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: beta1.MkSpec{
+			DbUsername:        "root",
+			DbPassword:        "secret",
+			MongoDbImage:      "mongo:7.0",
+			MongoExpressImage: "mongo-express:1.0",
+		},
+	}
+}
+
+func testStatefulSet(mkResource *beta1.Mk, monitoringSecret *v1.Secret, mongodConfig *v1.ConfigMap) *appsv1.StatefulSet {
+	secret := buildSecret(mkResource)
+	keyfile := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: mkResource.Name + "-keyfile"}}
+	headlessService := buildMongoHeadlessService(mkResource)
+	if monitoringSecret != nil {
+		mkResource = mkResource.DeepCopy()
+		mkResource.Spec.Monitoring = &beta1.MonitoringSpec{}
+	}
+	return buildMongoStatefulSet(mkResource, mkResource.Spec.MongoDbImage, secret, keyfile, monitoringSecret, mongodConfig, nil, headlessService, "")
+}
+
+// Fill in the defaults the api server sets on a pod template
+func withServerDefaults(template *v1.PodTemplateSpec) {
+	spec := &template.Spec
+	spec.RestartPolicy = v1.RestartPolicyAlways
+	spec.DNSPolicy = v1.DNSClusterFirst
+	spec.SchedulerName = v1.DefaultSchedulerName
+	spec.SecurityContext = &v1.PodSecurityContext{}
+	grace := int64(30)
+	spec.TerminationGracePeriodSeconds = &grace
+
+	containers := func(containers []v1.Container) {
+		for i := range containers {
+			c := &containers[i]
+			c.TerminationMessagePath = v1.TerminationMessagePathDefault
+			c.TerminationMessagePolicy = v1.TerminationMessageReadFile
+			if c.ImagePullPolicy == "" {
+				c.ImagePullPolicy = v1.PullIfNotPresent
+			}
+			for j := range c.Ports {
+				if c.Ports[j].Protocol == "" {
+					c.Ports[j].Protocol = v1.ProtocolTCP
+				}
+			}
+			if p := c.ReadinessProbe; p != nil {
+				p.TimeoutSeconds, p.SuccessThreshold, p.FailureThreshold = 1, 1, 3
+			}
+		}
+	}
+	containers(spec.InitContainers)
+	containers(spec.Containers)
+
+	mode := v1.SecretVolumeSourceDefaultMode
+	for i := range spec.Volumes {
+		if s := spec.Volumes[i].Secret; s != nil {
+			s.DefaultMode = &mode
+		}
+		if c := spec.Volumes[i].ConfigMap; c != nil {
+			c.DefaultMode = &mode
+		}
+	}
+}
+
+func TestStatefulSetMutator(t *testing.T) {
+	mkResource := testMk()
+	monitoringSecret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "test-monitoring"}}
+	mongodConfig := buildMongodConfigMap(mkResource, "systemLog:\n  verbosity: 1\n")
+
+	tests := []struct {
+		name     string
+		existing *appsv1.StatefulSet
+		desired  *appsv1.StatefulSet
+		changed  bool
+	}{
+		{
+			name:     "unchanged with server defaults",
+			existing: testStatefulSet(mkResource, monitoringSecret, mongodConfig),
+			desired:  testStatefulSet(mkResource, monitoringSecret, mongodConfig),
+		},
+		{
+			name:     "exporter removed",
+			existing: testStatefulSet(mkResource, monitoringSecret, nil),
+			desired:  testStatefulSet(mkResource, nil, nil),
+			changed:  true,
+		},
+		{
+			name:     "mongod.conf removed",
+			existing: testStatefulSet(mkResource, nil, mongodConfig),
+			desired:  testStatefulSet(mkResource, nil, nil),
+			changed:  true,
+		},
+		{
+			name:     "exporter added",
+			existing: testStatefulSet(mkResource, nil, nil),
+			desired:  testStatefulSet(mkResource, monitoringSecret, nil),
+			changed:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withServerDefaults(&tt.existing.Spec.Template)
+			// set by kubectl rollout restart, not owned by the controller
+			tt.existing.Spec.Template.Annotations = mergeMaps(tt.existing.Spec.Template.Annotations,
+				map[string]string{"kubectl.kubernetes.io/restartedAt": "2024-01-01T00:00:00Z"})
+
+			if changed := statefulSetMutator(tt.desired)(tt.existing); changed != tt.changed {
+				t.Fatalf("changed = %v, want %v", changed, tt.changed)
+			}
+			if !tt.changed {
+				return
+			}
+			template := tt.existing.Spec.Template.Spec
+			if got, want := len(template.Containers), len(tt.desired.Spec.Template.Spec.Containers); got != want {
+				t.Errorf("got %d containers, want %d", got, want)
+			}
+			if got, want := len(template.Volumes), len(tt.desired.Spec.Template.Spec.Volumes); got != want {
+				t.Errorf("got %d volumes, want %d", got, want)
+			}
+			for _, arg := range template.Containers[0].Args {
+				if arg == "--config" && tt.desired.Spec.Template.Annotations[configHashAnnotation] == "" {
+					t.Errorf("--config is still passed to mongod")
+				}
+			}
+		})
+	}
+}
+
+func TestDeploymentMutator(t *testing.T) {
+	mkResource := testMk()
+	secret := buildSecret(mkResource)
+	service := buildMongoService(mkResource, mongoExpressServiceConfig(mkResource))
+
+	existing := buildMongoExpressDeployment(mkResource, secret, service, "")
+	withServerDefaults(&existing.Spec.Template)
+	if deploymentMutator(buildMongoExpressDeployment(mkResource, secret, service, ""))(existing) {
+		t.Errorf("unchanged deployment is updated")
+	}
+
+	// an env var which is no longer set is removed
+	desired := buildMongoExpressDeployment(mkResource, secret, service, "")
+	container := &desired.Spec.Template.Spec.Containers[0]
+	container.Env = container.Env[:len(container.Env)-1]
+	if !deploymentMutator(desired)(existing) {
+		t.Fatalf("removed env var is not noticed")
+	}
+	if got, want := len(existing.Spec.Template.Spec.Containers[0].Env), len(container.Env); got != want {
+		t.Errorf("got %d env vars, want %d", got, want)
+	}
+
+	// maintenance scales the deployment down
+	mkResource.Spec.Maintenance = true
+	if !deploymentMutator(buildMongoExpressDeployment(mkResource, secret, service, ""))(existing) || *existing.Spec.Replicas != 0 {
+		t.Errorf("replicas are not updated")
+	}
+}
+
+func TestMongoExpressURL(t *testing.T) {
+	mkResource := testMk()
+	service := buildMongoService(mkResource, mongoDbServiceConfig(mkResource, testStatefulSet(mkResource, nil, nil)))
+	deployment := buildMongoExpressDeployment(mkResource, buildSecret(mkResource), service, "")
+
+	for _, env := range deployment.Spec.Template.Spec.Containers[0].Env {
+		if env.Name != "ME_CONFIG_MONGODB_URL" {
+			continue
+		}
+		if env.Value != "" || env.ValueFrom == nil || env.ValueFrom.SecretKeyRef == nil {
+			t.Fatalf("ME_CONFIG_MONGODB_URL = %+v, want a reference to the binding secret", env)
+		}
+		if ref := env.ValueFrom.SecretKeyRef; ref.Name != bindingSecretName(mkResource) || ref.Key != "uri" {
+			t.Errorf("ME_CONFIG_MONGODB_URL refers to %s of %s, want uri of %s", ref.Key, ref.Name, bindingSecretName(mkResource))
+		}
+		return
+	}
+	t.Errorf("ME_CONFIG_MONGODB_URL is not set")
+}
+
+func TestServiceMutator(t *testing.T) {
+	mkResource := testMk()
+	config := func(annotations map[string]string, metricsPort int32) MongoService {
+		return MongoService{
+			name:        "mongodb-service",
+			label:       mongoLabels(mkResource),
+			serviceType: v1.ServiceTypeLoadBalancer,
+			port:        27017,
+			annotations: annotations,
+			metricsPort: metricsPort,
+		}
+	}
+	// the api server fills in the protocol, target and node ports
+	withServerDefaults := func(service *v1.Service) *v1.Service {
+		service.Spec.ClusterIP = "10.0.0.1"
+		service.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyCluster
+		service.Spec.SessionAffinity = v1.ServiceAffinityNone
+		for i := range service.Spec.Ports {
+			port := &service.Spec.Ports[i]
+			port.Protocol = v1.ProtocolTCP
+			port.TargetPort = intstr.FromInt32(port.Port)
+			port.NodePort = 30000 + int32(i)
+		}
+		return service
+	}
+
+	tests := []struct {
+		name            string
+		existing        *v1.Service
+		desired         *v1.Service
+		changed         bool
+		wantAnnotations map[string]string
+		wantPorts       int
+	}{
+		{
+			name:      "unchanged with server defaults",
+			existing:  withServerDefaults(buildMongoService(mkResource, config(nil, 0))),
+			desired:   buildMongoService(mkResource, config(nil, 0)),
+			wantPorts: 1,
+		},
+		{
+			name:      "metrics port removed",
+			existing:  withServerDefaults(buildMongoService(mkResource, config(nil, exporterPort))),
+			desired:   buildMongoService(mkResource, config(nil, 0)),
+			changed:   true,
+			wantPorts: 1,
+		},
+		{
+			name:            "annotation removed",
+			existing:        withServerDefaults(buildMongoService(mkResource, config(map[string]string{"a": "1", "b": "2"}, 0))),
+			desired:         buildMongoService(mkResource, config(map[string]string{"a": "1"}, 0)),
+			changed:         true,
+			wantAnnotations: map[string]string{"a": "1", ownedAnnotationsAnnotation: "a"},
+			wantPorts:       1,
+		},
+		{
+			name:      "all annotations removed",
+			existing:  withServerDefaults(buildMongoService(mkResource, config(map[string]string{"a": "1"}, 0))),
+			desired:   buildMongoService(mkResource, config(nil, 0)),
+			changed:   true,
+			wantPorts: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clusterIP := tt.existing.Spec.ClusterIP
+			if changed := serviceMutator(tt.desired)(tt.existing); changed != tt.changed {
+				t.Fatalf("changed = %v, want %v", changed, tt.changed)
+			}
+			if !tt.changed {
+				return
+			}
+			if tt.existing.Spec.ClusterIP != clusterIP {
+				t.Errorf("cluster IP changed to %q", tt.existing.Spec.ClusterIP)
+			}
+			if got := len(tt.existing.Spec.Ports); got != tt.wantPorts {
+				t.Errorf("got %d ports, want %d", got, tt.wantPorts)
+			}
+			if tt.existing.Spec.Ports[0].NodePort != 30000 {
+				t.Errorf("allocated node port is not kept")
+			}
+			if len(tt.existing.Annotations) != len(tt.wantAnnotations) {
+				t.Fatalf("annotations = %v, want %v", tt.existing.Annotations, tt.wantAnnotations)
+			}
+			for k, v := range tt.wantAnnotations {
+				if tt.existing.Annotations[k] != v {
+					t.Errorf("annotations = %v, want %v", tt.existing.Annotations, tt.wantAnnotations)
+				}
+			}
+		})
+	}
+}
+
+func TestServiceMutatorServiceType(t *testing.T) {
+	mkResource := testMk()
+	service := func(serviceType v1.ServiceType, policy v1.ServiceExternalTrafficPolicy) *v1.Service {
+		return buildMongoService(mkResource, MongoService{
+			name:                  "mongodb-service",
+			label:                 mongoLabels(mkResource),
+			serviceType:           serviceType,
+			port:                  27017,
+			externalTrafficPolicy: policy,
+		})
+	}
+	// allocated by the api server
+	allocated := func(service *v1.Service) *v1.Service {
+		service.Spec.Ports[0].NodePort = 30000
+		if service.Spec.ExternalTrafficPolicy == v1.ServiceExternalTrafficPolicyLocal {
+			service.Spec.HealthCheckNodePort = 31000
+		}
+		return service
+	}
+
+	tests := []struct {
+		name            string
+		existing        *v1.Service
+		desired         *v1.Service
+		wantNodePort    int32
+		wantHealthCheck int32
+	}{
+		{
+			name:         "NodePort to LoadBalancer keeps the node port",
+			existing:     allocated(service(v1.ServiceTypeNodePort, "")),
+			desired:      service(v1.ServiceTypeLoadBalancer, ""),
+			wantNodePort: 30000,
+		},
+		{
+			name:     "NodePort to ClusterIP releases the node port",
+			existing: allocated(service(v1.ServiceTypeNodePort, "")),
+			desired:  service(v1.ServiceTypeClusterIP, ""),
+		},
+		{
+			name:            "Local LoadBalancer keeps the health check port",
+			existing:        allocated(service(v1.ServiceTypeLoadBalancer, v1.ServiceExternalTrafficPolicyLocal)),
+			desired:         service(v1.ServiceTypeLoadBalancer, v1.ServiceExternalTrafficPolicyLocal),
+			wantNodePort:    30000,
+			wantHealthCheck: 31000,
+		},
+		{
+			name:         "Local to Cluster releases the health check port",
+			existing:     allocated(service(v1.ServiceTypeLoadBalancer, v1.ServiceExternalTrafficPolicyLocal)),
+			desired:      service(v1.ServiceTypeLoadBalancer, v1.ServiceExternalTrafficPolicyCluster),
+			wantNodePort: 30000,
+		},
+		{
+			name:     "LoadBalancer to ClusterIP releases both",
+			existing: allocated(service(v1.ServiceTypeLoadBalancer, v1.ServiceExternalTrafficPolicyLocal)),
+			desired:  service(v1.ServiceTypeClusterIP, ""),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serviceMutator(tt.desired)(tt.existing)
+			if got := tt.existing.Spec.Ports[0].NodePort; got != tt.wantNodePort {
+				t.Errorf("node port = %d, want %d", got, tt.wantNodePort)
+			}
+			if got := tt.existing.Spec.HealthCheckNodePort; got != tt.wantHealthCheck {
+				t.Errorf("health check node port = %d, want %d", got, tt.wantHealthCheck)
+			}
+			if tt.existing.Spec.Type != tt.desired.Spec.Type {
+				t.Errorf("type = %s, want %s", tt.existing.Spec.Type, tt.desired.Spec.Type)
+			}
+		})
+	}
+}
+
+func TestOwnedAnnotations(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing map[string]string
+		desired  map[string]string
+		want     map[string]string
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:     "added next to others",
+			existing: map[string]string{"other": "x"},
+			desired:  map[string]string{"b": "2", "a": "1"},
+			want:     map[string]string{"other": "x", "a": "1", "b": "2", ownedAnnotationsAnnotation: "a,b"},
+		},
+		{
+			name:     "removed, others kept",
+			existing: map[string]string{"other": "x", "a": "1", "b": "2", ownedAnnotationsAnnotation: "a,b"},
+			desired:  map[string]string{"b": "3"},
+			want:     map[string]string{"other": "x", "b": "3", ownedAnnotationsAnnotation: "b"},
+		},
+		{
+			name:     "all removed",
+			existing: map[string]string{"a": "1", ownedAnnotationsAnnotation: "a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ownedAnnotations(tt.existing, tt.desired)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDefaultPullPolicy(t *testing.T) {
+	tests := map[string]v1.PullPolicy{
+		"mongo":                      v1.PullAlways,
+		"mongo:latest":               v1.PullAlways,
+		"mongo:7.0":                  v1.PullIfNotPresent,
+		"registry:5000/mongo":        v1.PullAlways,
+		"registry:5000/mongo:7.0":    v1.PullIfNotPresent,
+		"mongo@sha256:0123456789abc": v1.PullIfNotPresent,
+	}
+	for image, want := range tests {
+		if got := defaultPullPolicy(image); got != want {
+			t.Errorf("defaultPullPolicy(%q) = %s, want %s", image, got, want)
+		}
+	}
+}
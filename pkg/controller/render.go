@@ -0,0 +1,124 @@
+package controller
+
+import (
+	"fmt"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// Placeholders of the secrets which Render does not print. The password of the root user is
+// replaced everywhere it appears, the binding secret and its URIs included.
+const (
+	renderedPassword  = "REDACTED"
+	renderedGenerated = "GENERATED"
+)
+
+// Render returns the objects the controller creates for a Mk resource, in the order they are
+// created, without talking to a cluster. The result only depends on its arguments. It runs the
+// same builders as the reconcile, except that
+//   - dbPassword is rendered as REDACTED, and the keyfile and the monitoring password, which the
+//     controller generates when it creates their secrets, as GENERATED
+//   - Mk resources which adopt a statefulset are rejected, the adopted statefulset is the
+//     template of the one the controller writes
+//   - a mongod.conf referenced through spec.mongodConfig.configMapRef cannot be read, a
+//     placeholder is rendered instead
+//   - owner references are only set if the Mk resource has a UID, i.e. it was read from a cluster
+//   - the ServiceMonitor is rendered whether or not its CRD is installed
+func Render(mkResource *beta1.Mk, imageRegistry string) ([]runtime.Object, error) {
+	var objects []kubeObject
+
+	if adoptsStatefulSet(mkResource) {
+		return nil, fmt.Errorf("adopt cannot be rendered without a cluster, the controller keeps the pod template of StatefulSet %s", mkResource.Spec.Adopt.StatefulSet)
+	}
+	if err := validateServiceSpec(mkResource.Spec.Service); err != nil {
+		return nil, err
+	}
+
+	mkResource = mkResource.DeepCopy()
+	mkResource.Spec.DbPassword = renderedPassword
+
+	secret := buildSecret(mkResource)
+	keyfile := buildKeyfileSecret(mkResource, []byte(renderedGenerated))
+	headlessService := buildMongoHeadlessService(mkResource)
+	objects = append(objects, secret, keyfile, headlessService)
+
+	if podDisruptionBudget := buildPodDisruptionBudget(mkResource); podDisruptionBudget != nil {
+		objects = append(objects, podDisruptionBudget)
+	}
+	if networkPolicy := buildNetworkPolicy(mkResource); networkPolicy != nil {
+		objects = append(objects, networkPolicy)
+	}
+
+	var monitoringSecret *v1.Secret
+	if monitoringEnabled(mkResource) {
+		monitoringSecret = buildMonitoringSecret(mkResource, []byte(renderedGenerated))
+		objects = append(objects, monitoringSecret)
+	}
+
+	var mongodConfig *v1.ConfigMap
+	if config := mkResource.Spec.MongodConfig; config != nil {
+		content := config.Inline
+		if config.ConfigMapRef != nil {
+			content = fmt.Sprintf("# copied from ConfigMap %s when reconciled\n", config.ConfigMapRef.Name)
+		}
+		if err := validateMongodConfig(content); err != nil {
+			return nil, err
+		}
+		mongodConfig = buildMongodConfigMap(mkResource, content)
+		objects = append(objects, mongodConfig)
+	}
+
+	initMarker := buildInitMarkerConfigMap(mkResource)
+	if initMarker != nil {
+		objects = append(objects, initMarker)
+	}
+
+	// Without a cluster there is no upgrade in progress, the members run spec.mongoDbImage
+	statefulSet := buildMongoStatefulSet(mkResource, mkResource.Spec.MongoDbImage, secret, keyfile, monitoringSecret, mongodConfig, initMarker, headlessService, imageRegistry)
+	mongoDbService := buildMongoService(mkResource, mongoDbServiceConfig(mkResource, statefulSet))
+	objects = append(objects, statefulSet, mongoDbService)
+
+	if mkResource.Spec.Service != nil && mkResource.Spec.Service.External != nil {
+		for i := int32(0); i < mkResource.Replicas(); i++ {
+			objects = append(objects, buildExternalService(mkResource, statefulSet, mkResource.Spec.Service.External, i))
+		}
+	}
+
+	objects = append(objects, buildBindingSecret(mkResource, mongoDbService, headlessService, statefulSet.Name))
+	if serviceMonitorEnabled(mkResource) {
+		objects = append(objects, buildServiceMonitor(mkResource, mongoDbService))
+	}
+
+	objects = append(objects, buildMongoExpressDeployment(mkResource, secret, mongoDbService, imageRegistry))
+	objects = append(objects, buildMongoService(mkResource, mongoExpressServiceConfig(mkResource)))
+
+	rendered := make([]runtime.Object, 0, len(objects))
+	for _, obj := range objects {
+		if err := setTypeMeta(obj); err != nil {
+			return nil, err
+		}
+		setOwnership(mkResource, obj)
+		if mkResource.UID == "" {
+			obj.SetOwnerReferences(nil)
+		}
+		rendered = append(rendered, obj)
+	}
+	return rendered, nil
+}
+
+// Set apiVersion and kind of a typed object, which are left empty by the builders
+func setTypeMeta(obj runtime.Object) error {
+	if !obj.GetObjectKind().GroupVersionKind().Empty() {
+		return nil
+	}
+	kinds, _, err := scheme.Scheme.ObjectKinds(obj)
+	if err != nil {
+		return err
+	}
+	obj.GetObjectKind().SetGroupVersionKind(kinds[0])
+	return nil
+}
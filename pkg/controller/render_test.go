@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestRender(t *testing.T) {
+	mkResource := testMk()
+	mkResource.Spec.DbPassword = "s3cr&t"
+	mkResource.Spec.Monitoring = &beta1.MonitoringSpec{}
+
+	first, err := Render(mkResource, "registry.example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, err := Render(mkResource, "registry.example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("two renders of the same Mk differ")
+	}
+	if mkResource.Spec.DbPassword != "s3cr&t" {
+		t.Errorf("Render changed the Mk resource")
+	}
+
+	out, err := yaml.Marshal(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "s3cr") {
+		t.Errorf("dbPassword is rendered:\n%s", out)
+	}
+
+	secrets := map[string]*v1.Secret{}
+	for _, obj := range first {
+		if secret, ok := obj.(*v1.Secret); ok {
+			secrets[secret.Name] = secret
+		}
+	}
+	for name, want := range map[string]map[string]string{
+		"mongodb-secret":  {"password": renderedPassword},
+		"test-keyfile":    {"keyfile": renderedGenerated},
+		"test-monitoring": {"password": renderedGenerated},
+		"test-binding":    {"password": renderedPassword},
+	} {
+		secret := secrets[name]
+		if secret == nil {
+			t.Errorf("secret %s is not rendered", name)
+			continue
+		}
+		for key, value := range want {
+			got := string(secret.Data[key])
+			if got == "" {
+				got = secret.StringData[key]
+			}
+			if got != value {
+				t.Errorf("%s of secret %s = %q, want %q", key, name, got, value)
+			}
+		}
+	}
+}
+
+func TestRenderAdopt(t *testing.T) {
+	mkResource := testMk()
+	mkResource.Spec.Adopt = &beta1.AdoptSpec{StatefulSet: "mongo", Service: "mongo-headless"}
+
+	if _, err := Render(mkResource, ""); err == nil || !strings.Contains(err.Error(), "StatefulSet mongo") {
+		t.Errorf("error = %v, want adopt to be rejected", err)
+	}
+}
@@ -0,0 +1,311 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+	"mongokube/pkg/connect"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	clusterDomain = "cluster.local"
+
+	// Error code returned by replSetGetStatus before rs.initiate() has been run
+	codeNotYetInitialized = 94
+)
+
+// State of the replica set as seen by one of its members
+type replicaSetStatus struct {
+	Initialized bool     `json:"initialized"`
+	Code        int      `json:"code"`
+	Primary     string   `json:"primary"`
+	Members     []string `json:"members"`
+	// state of the members, e.g. PRIMARY, SECONDARY or STARTUP2 during the initial sync, by host
+	States map[string]string `json:"states"`
+	// external horizon of the members which have one, by host
+	Horizons map[string]string `json:"horizons"`
+}
+
+// Works with both the legacy mongo shell and mongosh, the latter throws instead of returning ok: 0
+const replicaSetStatusScript = `
+var out = {initialized: false, code: 0, primary: "", members: [], states: {}, horizons: {}};
+try {
+  var s = db.adminCommand({replSetGetStatus: 1});
+  if (s.ok) {
+    out.initialized = true;
+    s.members.forEach(function (m) {
+      out.members.push(m.name);
+      out.states[m.name] = m.stateStr;
+      if (m.stateStr === "PRIMARY") { out.primary = m.name; }
+    });
+    var c = db.adminCommand({replSetGetConfig: 1});
+    if (c.ok) {
+      c.config.members.forEach(function (m) {
+        if (m.horizons && m.horizons.` + externalHorizon + `) { out.horizons[m.host] = m.horizons.` + externalHorizon + `; }
+      });
+    }
+  } else {
+    out.code = s.code;
+  }
+} catch (e) {
+  out.code = e.code;
+}
+print(JSON.stringify(out));
+`
+
+// Make the shell exit non-zero if a replica set helper returns ok: 0 (legacy mongo shell)
+func mongoCommandScript(command string) string {
+	return fmt.Sprintf("var r = %s; if (r && r.ok === 0) { print(JSON.stringify(r)); quit(1); }", command)
+}
+
+// Command which evaluates the script with mongosh, or the legacy mongo shell for older images,
+// authenticated as the root user created from the MONGO_INITDB_ROOT_* variables
+func mongoShellCommand(script string) []string {
+	return []string{
+		"sh", "-c",
+		`exec "$(command -v mongosh || command -v mongo)" --quiet -u "$MONGO_INITDB_ROOT_USERNAME" -p "$MONGO_INITDB_ROOT_PASSWORD" --authenticationDatabase admin --eval "$1"`,
+		"sh", script,
+	}
+}
+
+// Evaluate the script in the MongoDB container of the given pod
+func (c *Controller) mongoEval(ctx context.Context, mkResource *beta1.Mk, pod string, script string) (string, error) {
+	return c.executor.Exec(ctx, mkResource.Namespace, pod, mkResource.MongoContainerName(), mongoShellCommand(script))
+}
+
+// Ask a member for the state of the replica set
+func (c *Controller) replicaSetStatus(ctx context.Context, mkResource *beta1.Mk, pod string) (*replicaSetStatus, error) {
+	out, err := c.mongoEval(ctx, mkResource, pod, replicaSetStatusScript)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &replicaSetStatus{}
+	if err := json.Unmarshal([]byte(lastLine(out)), status); err != nil {
+		return nil, fmt.Errorf("parsing replica set status %q: %w", out, err)
+	}
+
+	return status, nil
+}
+
+// Pod of the primary of the replica set, empty during elections
+func (c *Controller) primaryPod(ctx context.Context, mkResource *beta1.Mk, statefulSet *appsv1.StatefulSet) (string, error) {
+	status, err := c.replicaSetStatus(ctx, mkResource, statefulSetPod(statefulSet, 0))
+	if err != nil || status.Primary == "" {
+		return "", err
+	}
+	return memberPod(status.Primary), nil
+}
+
+// Last line printed by the shell, anything before are shell warnings
+func lastLine(out string) string {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	return lines[len(lines)-1]
+}
+
+// Name of the pod with the given ordinal
+func statefulSetPod(statefulSet *appsv1.StatefulSet, ordinal int32) string {
+	return fmt.Sprintf("%s-%d", statefulSet.Name, ordinal)
+}
+
+// Host of the pod with the given ordinal in the replica set config
+func memberHost(statefulSet *appsv1.StatefulSet, ordinal int32) string {
+	return fmt.Sprintf("%s.%s.%s.svc.%s:27017", statefulSetPod(statefulSet, ordinal), statefulSet.Spec.ServiceName, statefulSet.Namespace, clusterDomain)
+}
+
+// Member document for rs.initiate() and rs.add(), with the external horizon if there is one.
+// Either all members have horizons or none.
+func memberConfig(statefulSet *appsv1.StatefulSet, ordinal int32, horizons map[int32]string) string {
+	if horizon, ok := horizons[ordinal]; ok {
+		return fmt.Sprintf("{_id: %d, host: %q, horizons: {%s: %q}}", ordinal, memberHost(statefulSet, ordinal), externalHorizon, horizon)
+	}
+	return fmt.Sprintf("{_id: %d, host: %q}", ordinal, memberHost(statefulSet, ordinal))
+}
+
+// Set the external horizons of all members in one reconfig, or remove them if there are none
+const reconfigHorizonsScript = `var horizons = %s;
+var cfg = rs.conf();
+cfg.members.forEach(function (m) {
+  if (horizons[m.host]) { m.horizons = {%s: horizons[m.host]}; } else { delete m.horizons; }
+});
+var r = rs.reconfig(cfg);
+if (r && r.ok === 0) { print(JSON.stringify(r)); quit(1); }`
+
+// Name of the pod of a replica set member
+func memberPod(host string) string {
+	return strings.SplitN(host, ".", 2)[0]
+}
+
+// Check whether the pod exists and passes its readiness probe
+func (c *Controller) podReady(ctx context.Context, namespace, name string) (bool, error) {
+	pod, err := c.k8sclient.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return connect.IsPodReady(pod), nil
+}
+
+// Change the number of pods of the statefulset
+func (c *Controller) scaleStatefulSet(ctx context.Context, statefulSet *appsv1.StatefulSet, replicas int32) error {
+	klog.FromContext(ctx).Info("Scaling statefulset", "statefulSet", klog.KObj(statefulSet), "replicas", replicas)
+
+	statefulSetCopy := statefulSet.DeepCopy()
+	statefulSetCopy.Spec.Replicas = &replicas
+	_, err := c.k8sclient.AppsV1().StatefulSets(statefulSet.Namespace).Update(ctx, statefulSetCopy, metav1.UpdateOptions{})
+
+	return err
+}
+
+// Bring the replica set in line with spec.replicas. Members are changed one at a time,
+// pods are only removed from the statefulset after they have been removed from the
+// replica set config, and a primary which is about to be removed is stepped down first.
+// Members get the external horizons given by ordinal, if any.
+// Returns true once the replica set has the desired members and all of them are ready.
+func (c *Controller) reconcileReplicaSet(ctx context.Context, mkResource *beta1.Mk, statefulSet *appsv1.StatefulSet, horizons map[int32]string) (bool, error) {
+	logger := klog.FromContext(ctx)
+	desired := mkResource.Replicas()
+	current := int32(1)
+	if statefulSet.Spec.Replicas != nil {
+		current = *statefulSet.Spec.Replicas
+	}
+
+	// The first member is never removed, so it is used to inspect the replica set
+	firstPod := statefulSetPod(statefulSet, 0)
+	ready, err := c.podReady(ctx, statefulSet.Namespace, firstPod)
+	if err != nil || !ready {
+		return false, err
+	}
+
+	status, err := c.replicaSetStatus(ctx, mkResource, firstPod)
+	if err != nil {
+		return false, err
+	}
+
+	if !status.Initialized {
+		if status.Code != codeNotYetInitialized {
+			return false, fmt.Errorf("replica set status returned error code %d", status.Code)
+		}
+
+		// Start with the first member only, the others are added as soon as they are ready
+		logger.Info("Initiating replica set", "replicaSet", beta1.ReplicaSetName)
+		_, err := c.mongoEval(ctx, mkResource, firstPod, mongoCommandScript(fmt.Sprintf(
+			"rs.initiate({_id: %q, members: [%s]})", beta1.ReplicaSetName, memberConfig(statefulSet, 0, horizons))))
+		if err == nil {
+			c.recorder.Eventf(mkResource, v1.EventTypeNormal, reasonReplicaSetInitiated, "Initiated replica set %s with member %s", beta1.ReplicaSetName, memberHost(statefulSet, 0))
+		}
+		return false, err
+	}
+
+	if status.Primary == "" {
+		// election in progress
+		return false, nil
+	}
+	primaryPod := memberPod(status.Primary)
+
+	members := map[string]bool{}
+	for _, member := range status.Members {
+		members[member] = true
+	}
+
+	desiredHosts := map[string]bool{}
+	for i := int32(0); i < desired; i++ {
+		desiredHosts[memberHost(statefulSet, i)] = true
+	}
+
+	// Remove members which are not wanted anymore, newest first. This also covers
+	// members left over after the statefulset has been scaled down by hand.
+	for i := len(status.Members) - 1; i >= 0; i-- {
+		host := status.Members[i]
+		if desiredHosts[host] {
+			continue
+		}
+
+		if host == status.Primary {
+			// The member is removed in one of the next rounds, once another member has been elected
+			logger.Info("Stepping down primary before removing it", "member", host)
+			_, err := c.mongoEval(ctx, mkResource, primaryPod, mongoCommandScript("rs.stepDown(60)"))
+			if err != nil {
+				// the shell may lose its connection while the primary steps down
+				logger.V(2).Info("Stepping down primary returned an error", "member", host, "err", err)
+			}
+			c.recorder.Eventf(mkResource, v1.EventTypeNormal, reasonPrimarySteppedDown, "Stepped down primary %s before removing it", host)
+			return false, nil
+		}
+
+		logger.Info("Removing member from replica set", "member", host)
+		_, err := c.mongoEval(ctx, mkResource, primaryPod, mongoCommandScript(fmt.Sprintf("rs.remove(%q)", host)))
+		if err == nil {
+			c.recorder.Eventf(mkResource, v1.EventTypeNormal, reasonMemberRemoved, "Removed member %s from replica set", host)
+		}
+		return false, err
+	}
+
+	// Pods are only removed after their members have left the replica set
+	if current != desired {
+		if err := c.scaleStatefulSet(ctx, statefulSet, desired); err != nil {
+			return false, err
+		}
+		c.recorder.Eventf(mkResource, v1.EventTypeNormal, reasonScaled, "Scaled statefulset %s from %d to %d replicas", statefulSet.Name, current, desired)
+		return false, nil
+	}
+
+	// Add members which are running but not part of the replica set yet, one at a time
+	for i := int32(1); i < desired; i++ {
+		host := memberHost(statefulSet, i)
+		if members[host] {
+			continue
+		}
+
+		ready, err := c.podReady(ctx, statefulSet.Namespace, statefulSetPod(statefulSet, i))
+		if err != nil || !ready {
+			return false, err
+		}
+
+		logger.Info("Adding member to replica set", "member", host)
+		_, err = c.mongoEval(ctx, mkResource, primaryPod, mongoCommandScript(fmt.Sprintf("rs.add(%s)", memberConfig(statefulSet, i, horizons))))
+		if err == nil {
+			c.recorder.Eventf(mkResource, v1.EventTypeNormal, reasonMemberAdded, "Added member %s to replica set", host)
+		}
+		return false, err
+	}
+
+	// Horizons of all members are changed together, as they must either all have one or none
+	desiredHorizons := map[string]string{}
+	for ordinal, horizon := range horizons {
+		desiredHorizons[memberHost(statefulSet, ordinal)] = horizon
+	}
+	if !reflect.DeepEqual(desiredHorizons, status.Horizons) && len(desiredHorizons)+len(status.Horizons) > 0 {
+		if len(desiredHorizons) > 0 && len(desiredHorizons) != len(status.Members) {
+			// waiting for the external services of all members
+			return false, nil
+		}
+
+		horizonsJSON, err := json.Marshal(desiredHorizons)
+		if err != nil {
+			return false, err
+		}
+
+		logger.Info("Reconfiguring external horizons", "horizons", desiredHorizons)
+		_, err = c.mongoEval(ctx, mkResource, primaryPod, fmt.Sprintf(reconfigHorizonsScript, horizonsJSON, externalHorizon))
+		if err == nil {
+			c.recorder.Eventf(mkResource, v1.EventTypeNormal, reasonHorizonsConfigured, "Configured external horizons of %d members", len(desiredHorizons))
+		}
+		return false, err
+	}
+
+	return statefulSet.Status.ReadyReplicas == desired, nil
+}
@@ -0,0 +1,185 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestReconcileReplicaSet(t *testing.T) {
+	mkResource := testMk()
+	statefulSet := testStatefulSet(mkResource, nil, nil)
+	host := func(ordinal int32) string { return memberHost(statefulSet, ordinal) }
+	readyPod := func(ordinal int32) runtime.Object {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: statefulSetPod(statefulSet, ordinal), Namespace: "default"},
+			Status:     v1.PodStatus{Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionTrue}}},
+		}
+	}
+
+	tests := []struct {
+		name         string
+		replicas     int32
+		current      int32
+		pods         []runtime.Object
+		status       replicaSetStatus
+		wantScripts  []execCall
+		wantReplicas int32
+		wantEvent    string
+	}{
+		{
+			name:     "first member not ready",
+			replicas: 3,
+			current:  1,
+		},
+		{
+			name:     "initiate",
+			replicas: 3,
+			current:  1,
+			pods:     []runtime.Object{readyPod(0)},
+			status:   replicaSetStatus{Code: codeNotYetInitialized},
+			wantScripts: []execCall{{
+				pod:    "test-mongodb-0",
+				script: mongoCommandScript(`rs.initiate({_id: "rs0", members: [{_id: 0, host: "` + host(0) + `"}]})`),
+			}},
+			wantReplicas: 1,
+			wantEvent:    reasonReplicaSetInitiated,
+		},
+		{
+			name:         "scale up the statefulset",
+			replicas:     3,
+			current:      1,
+			pods:         []runtime.Object{readyPod(0)},
+			status:       replicaSetStatus{Initialized: true, Primary: host(0), Members: []string{host(0)}},
+			wantReplicas: 3,
+			wantEvent:    reasonScaled,
+		},
+		{
+			name:     "add a ready member",
+			replicas: 3,
+			current:  3,
+			pods:     []runtime.Object{readyPod(0), readyPod(1)},
+			status:   replicaSetStatus{Initialized: true, Primary: host(0), Members: []string{host(0)}},
+			wantScripts: []execCall{{
+				pod:    "test-mongodb-0",
+				script: mongoCommandScript(`rs.add({_id: 1, host: "` + host(1) + `"})`),
+			}},
+			wantReplicas: 3,
+			wantEvent:    reasonMemberAdded,
+		},
+		{
+			name:         "wait for the next member to be ready",
+			replicas:     3,
+			current:      3,
+			pods:         []runtime.Object{readyPod(0)},
+			status:       replicaSetStatus{Initialized: true, Primary: host(0), Members: []string{host(0), host(1)}},
+			wantReplicas: 3,
+		},
+		{
+			name:     "remove a secondary before scaling down",
+			replicas: 1,
+			current:  3,
+			pods:     []runtime.Object{readyPod(0)},
+			status:   replicaSetStatus{Initialized: true, Primary: host(0), Members: []string{host(0), host(1), host(2)}},
+			wantScripts: []execCall{{
+				pod:    "test-mongodb-0",
+				script: mongoCommandScript(`rs.remove("` + host(2) + `")`),
+			}},
+			wantReplicas: 3,
+			wantEvent:    reasonMemberRemoved,
+		},
+		{
+			name:     "step down a primary before removing it",
+			replicas: 1,
+			current:  2,
+			pods:     []runtime.Object{readyPod(0)},
+			status:   replicaSetStatus{Initialized: true, Primary: host(1), Members: []string{host(0), host(1)}},
+			wantScripts: []execCall{{
+				pod:    "test-mongodb-1",
+				script: mongoCommandScript("rs.stepDown(60)"),
+			}},
+			wantReplicas: 2,
+			wantEvent:    reasonPrimarySteppedDown,
+		},
+		{
+			name:         "scale down the statefulset once the members are removed",
+			replicas:     1,
+			current:      3,
+			pods:         []runtime.Object{readyPod(0)},
+			status:       replicaSetStatus{Initialized: true, Primary: host(0), Members: []string{host(0)}},
+			wantReplicas: 1,
+			wantEvent:    reasonScaled,
+		},
+		{
+			name:         "election in progress",
+			replicas:     3,
+			current:      3,
+			pods:         []runtime.Object{readyPod(0)},
+			status:       replicaSetStatus{Initialized: true, Members: []string{host(0), host(1), host(2)}},
+			wantReplicas: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mkResource := mkResource.DeepCopy()
+			mkResource.Spec.Replicas = &tt.replicas
+			statefulSet := statefulSet.DeepCopy()
+			statefulSet.Spec.Replicas = &tt.current
+
+			c, k8sclient := testController(mkResource, append(tt.pods, statefulSet)...)
+			status, err := json.Marshal(tt.status)
+			if err != nil {
+				t.Fatal(err)
+			}
+			executor := &fakeExecutor{respond: func(pod, script string) (string, error) {
+				if script == replicaSetStatusScript {
+					return string(status), nil
+				}
+				return "", nil
+			}}
+			c.executor = executor
+
+			if _, err := c.reconcileReplicaSet(context.Background(), mkResource, statefulSet, nil); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var scripts []execCall
+			for _, call := range executor.calls {
+				if call.script != replicaSetStatusScript {
+					scripts = append(scripts, call)
+				}
+			}
+			if !reflect.DeepEqual(scripts, tt.wantScripts) {
+				t.Errorf("scripts = %v, want %v", scripts, tt.wantScripts)
+			}
+
+			updated, err := k8sclient.AppsV1().StatefulSets("default").Get(context.Background(), statefulSet.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantReplicas != 0 && *updated.Spec.Replicas != tt.wantReplicas {
+				t.Errorf("statefulset replicas = %d, want %d", *updated.Spec.Replicas, tt.wantReplicas)
+			}
+
+			events := c.recorder.(*record.FakeRecorder).Events
+			select {
+			case event := <-events:
+				if tt.wantEvent == "" || !strings.Contains(event, tt.wantEvent) {
+					t.Errorf("event = %q, want %q", event, tt.wantEvent)
+				}
+			default:
+				if tt.wantEvent != "" {
+					t.Errorf("no event, want %q", tt.wantEvent)
+				}
+			}
+		})
+	}
+}
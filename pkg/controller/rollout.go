@@ -0,0 +1,194 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+	"mongokube/pkg/connect"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+// State of a rollout of the statefulset template to the members
+type rollout struct {
+	// whether all members run the current template
+	done bool
+	// what the rollout is doing or waiting for
+	step string
+	// pod of the primary, once it is known
+	primaryPod string
+	// whether the members were compared with the template, blocked is only known then
+	checked bool
+	// reason of the RolloutBlocked condition if members cannot be restarted safely, empty otherwise
+	blocked string
+	// why members cannot be restarted
+	blockedMessage string
+}
+
+// Type of the status condition which tells whether outdated members are left alone
+const conditionRolloutBlocked = "RolloutBlocked"
+
+// Reasons of the RolloutBlocked condition
+const (
+	rolloutReasonNoPersistentStorage = "NoPersistentStorage"
+	rolloutReasonSingleMember        = "SingleMember"
+	rolloutReasonAllowed             = "RolloutAllowed"
+)
+
+// Why members of the statefulset cannot be restarted without losing data or availability. A
+// member without a volume claim comes back empty, and the only member of a replica set takes
+// the instance down while it restarts.
+func rolloutBlocked(statefulSet *appsv1.StatefulSet) (reason string, message string) {
+	if len(statefulSet.Spec.VolumeClaimTemplates) == 0 {
+		return rolloutReasonNoPersistentStorage, fmt.Sprintf("StatefulSet %s has no volume claim templates, restarting a member would lose its data; "+
+			"delete the statefulset with --cascade=orphan to have it recreated with persistent storage", statefulSet.Name)
+	}
+	if statefulSet.Spec.Replicas == nil || *statefulSet.Spec.Replicas < 2 {
+		return rolloutReasonSingleMember, "Replica set has a single member, restarting it would take the instance down; scale to at least 2 members"
+	}
+	return "", ""
+}
+
+// Record in the status whether the rollout is blocked, once rollMembers could tell
+func setRolloutCondition(status *beta1.MkStatus, generation int64, state rollout) {
+	if !state.checked {
+		return
+	}
+	condition := metav1.Condition{
+		Type:               conditionRolloutBlocked,
+		Status:             metav1.ConditionFalse,
+		Reason:             rolloutReasonAllowed,
+		ObservedGeneration: generation,
+	}
+	if state.blocked != "" {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = state.blocked
+		condition.Message = state.blockedMessage
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+}
+
+// Member which is neither primary nor secondary, e.g. during its initial sync, empty if there is none
+func unavailableMember(status *replicaSetStatus) string {
+	members := append([]string{}, status.Members...)
+	sort.Strings(members)
+	for _, member := range members {
+		if state := status.States[member]; state != "PRIMARY" && state != "SECONDARY" {
+			return member
+		}
+	}
+	return ""
+}
+
+// Ordinal of a statefulset pod, e.g. 2 for mongokube-test-mongodb-2
+func podOrdinal(name string) int {
+	ordinal, _ := strconv.Atoi(name[strings.LastIndex(name, "-")+1:])
+	return ordinal
+}
+
+// Move the members to the template of the statefulset, which uses the OnDelete update strategy.
+// Once all members are ready, outdated secondaries are restarted one at a time, newest first,
+// then the primary is stepped down and restarted as well. The reason is added to the events.
+// Members are never restarted while one of them is syncing, nor if rolloutBlocked refuses it.
+func (c *Controller) rollMembers(ctx context.Context, mkResource *beta1.Mk, statefulSet *appsv1.StatefulSet, reason string) (rollout, error) {
+	logger := klog.FromContext(ctx)
+
+	if statefulSet.Status.ObservedGeneration < statefulSet.Generation || statefulSet.Status.UpdateRevision == "" {
+		return rollout{step: "waiting for the statefulset to be updated"}, nil
+	}
+
+	podList, err := c.k8sclient.CoreV1().Pods(statefulSet.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(statefulSet.Spec.Selector.MatchLabels).String(),
+	})
+	if err != nil {
+		return rollout{}, err
+	}
+	// pods of others may carry the same labels, e.g. the one of the legacy deployment
+	var pods []v1.Pod
+	for _, pod := range podList.Items {
+		if owner := metav1.GetControllerOf(&pod); owner != nil && owner.UID == statefulSet.UID {
+			pods = append(pods, pod)
+		}
+	}
+
+	var outdated []v1.Pod
+	for _, pod := range pods {
+		if pod.Labels[appsv1.StatefulSetRevisionLabel] != statefulSet.Status.UpdateRevision {
+			outdated = append(outdated, pod)
+		}
+	}
+	if len(outdated) > 0 {
+		if blocked, message := rolloutBlocked(statefulSet); blocked != "" {
+			if !meta.IsStatusConditionTrue(mkResource.Status.Conditions, conditionRolloutBlocked) {
+				c.recorder.Event(mkResource, v1.EventTypeWarning, reasonRolloutBlocked, message)
+			}
+			return rollout{step: "rollout is blocked", checked: true, blocked: blocked, blockedMessage: message}, nil
+		}
+	}
+
+	// Never take a member down while another one is unavailable
+	if int32(len(pods)) < *statefulSet.Spec.Replicas {
+		return rollout{step: "waiting for all members to be created", checked: true}, nil
+	}
+	for _, pod := range pods {
+		if !connect.IsPodReady(&pod) {
+			return rollout{step: fmt.Sprintf("waiting for member %s to be ready", pod.Name), checked: true}, nil
+		}
+	}
+
+	status, err := c.replicaSetStatus(ctx, mkResource, statefulSetPod(statefulSet, 0))
+	if err != nil {
+		return rollout{}, err
+	}
+	if status.Primary == "" {
+		return rollout{step: "waiting for a primary", checked: true}, nil
+	}
+	// a ready member may still copy the data of the others
+	if member := unavailableMember(status); member != "" && len(outdated) > 0 {
+		return rollout{step: fmt.Sprintf("waiting for member %s to be primary or secondary", member), checked: true, primaryPod: memberPod(status.Primary)}, nil
+	}
+
+	state := rollout{
+		done:       len(outdated) == 0,
+		checked:    true,
+		step:       fmt.Sprintf("%d of %d members updated", len(pods)-len(outdated), len(pods)),
+		primaryPod: memberPod(status.Primary),
+	}
+
+	// Secondaries first, newest first
+	sort.Slice(outdated, func(i, j int) bool { return podOrdinal(outdated[i].Name) > podOrdinal(outdated[j].Name) })
+	for _, pod := range outdated {
+		if pod.Name == state.primaryPod {
+			continue
+		}
+
+		logger.Info("Restarting secondary", "pod", pod.Name, "reason", reason)
+		if err := c.k8sclient.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return state, err
+		}
+		c.recorder.Eventf(mkResource, v1.EventTypeNormal, reasonMemberRestarted, "Restarting member %s %s", pod.Name, reason)
+		return state, nil
+	}
+
+	if len(outdated) > 0 {
+		// The primary is restarted in one of the next rounds, once another member has been elected
+		logger.Info("Stepping down primary before restarting it", "member", status.Primary)
+		if _, err := c.mongoEval(ctx, mkResource, state.primaryPod, mongoCommandScript("rs.stepDown(60)")); err != nil {
+			// the shell may lose its connection while the primary steps down
+			logger.V(2).Info("Stepping down primary returned an error", "member", status.Primary, "err", err)
+		}
+		c.recorder.Eventf(mkResource, v1.EventTypeNormal, reasonPrimarySteppedDown, "Stepped down primary %s before restarting it", status.Primary)
+	}
+
+	return state, nil
+}
@@ -0,0 +1,108 @@
+package controller
+
+import (
+	"testing"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRolloutBlocked(t *testing.T) {
+	tests := []struct {
+		name     string
+		replicas int32
+		claims   bool
+		want     string
+	}{
+		{name: "persistent replica set", replicas: 3, claims: true},
+		{name: "two members", replicas: 2, claims: true},
+		{name: "single member", replicas: 1, claims: true, want: rolloutReasonSingleMember},
+		{name: "no persistent storage", replicas: 3, want: rolloutReasonNoPersistentStorage},
+		{name: "single member without persistent storage", replicas: 1, want: rolloutReasonNoPersistentStorage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulSet := testStatefulSet(testMk(), nil, nil)
+			statefulSet.Spec.Replicas = &tt.replicas
+			if !tt.claims {
+				statefulSet.Spec.VolumeClaimTemplates = nil
+			}
+
+			reason, message := rolloutBlocked(statefulSet)
+			if reason != tt.want {
+				t.Errorf("reason = %q, want %q", reason, tt.want)
+			}
+			if (reason == "") != (message == "") {
+				t.Errorf("reason %q comes with message %q", reason, message)
+			}
+		})
+	}
+}
+
+func TestSetRolloutCondition(t *testing.T) {
+	status := &beta1.MkStatus{}
+
+	setRolloutCondition(status, 1, rollout{step: "waiting for the statefulset to be updated"})
+	if len(status.Conditions) != 0 {
+		t.Fatalf("condition set before the members were compared: %v", status.Conditions)
+	}
+
+	setRolloutCondition(status, 2, rollout{checked: true, blocked: rolloutReasonSingleMember, blockedMessage: "single member"})
+	condition := meta.FindStatusCondition(status.Conditions, conditionRolloutBlocked)
+	if condition == nil || condition.Status != metav1.ConditionTrue || condition.Reason != rolloutReasonSingleMember || condition.ObservedGeneration != 2 {
+		t.Fatalf("condition = %v, want blocked by a single member", condition)
+	}
+
+	// an unrelated wait keeps the condition
+	setRolloutCondition(status, 2, rollout{})
+	if !meta.IsStatusConditionTrue(status.Conditions, conditionRolloutBlocked) {
+		t.Errorf("condition reset without comparing the members")
+	}
+
+	setRolloutCondition(status, 3, rollout{checked: true, done: true})
+	condition = meta.FindStatusCondition(status.Conditions, conditionRolloutBlocked)
+	if condition == nil || condition.Status != metav1.ConditionFalse || condition.Message != "" {
+		t.Errorf("condition = %v, want not blocked", condition)
+	}
+}
+
+func TestUnavailableMember(t *testing.T) {
+	members := []string{"test-mongodb-0.test:27017", "test-mongodb-1.test:27017", "test-mongodb-2.test:27017"}
+	tests := []struct {
+		name   string
+		states map[string]string
+		want   string
+	}{
+		{
+			name:   "all available",
+			states: map[string]string{members[0]: "PRIMARY", members[1]: "SECONDARY", members[2]: "SECONDARY"},
+		},
+		{
+			name:   "initial sync",
+			states: map[string]string{members[0]: "PRIMARY", members[1]: "SECONDARY", members[2]: "STARTUP2"},
+			want:   members[2],
+		},
+		{
+			name:   "recovering",
+			states: map[string]string{members[0]: "SECONDARY", members[1]: "RECOVERING", members[2]: "PRIMARY"},
+			want:   members[1],
+		},
+		{
+			name:   "state unknown",
+			states: map[string]string{members[0]: "PRIMARY", members[1]: "SECONDARY"},
+			want:   members[2],
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := unavailableMember(&replicaSetStatus{Members: members, States: tt.states})
+			if got != tt.want {
+				t.Errorf("unavailableMember() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"mongokube/pkg/apis/mongokube/beta1"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Name of the volume claim template holding the data of a member, mounted at mongoDataDir
+const dataVolumeName = "data"
+
+// Path the MongoDB image keeps its data in
+const mongoDataDir = "/data/db"
+
+var (
+	defaultStorageSize = resource.MustParse("1Gi")
+
+	// Requests of the MongoDB container without spec.resources, so that the pods can be scheduled
+	// sensibly and a HorizontalPodAutoscaler can compute the CPU utilization
+	defaultMongoRequests = v1.ResourceList{
+		v1.ResourceCPU:    resource.MustParse("100m"),
+		v1.ResourceMemory: resource.MustParse("256Mi"),
+	}
+	exporterRequests = v1.ResourceList{
+		v1.ResourceCPU:    resource.MustParse("10m"),
+		v1.ResourceMemory: resource.MustParse("32Mi"),
+	}
+)
+
+// Volume claim template of the data volume of every member, as requested by spec.storage
+func dataVolumeClaim(mkResource *beta1.Mk) v1.PersistentVolumeClaim {
+	size := defaultStorageSize
+	var storageClassName *string
+	if storage := mkResource.Spec.Storage; storage != nil {
+		if storage.Size != nil {
+			size = *storage.Size
+		}
+		storageClassName = storage.StorageClassName
+	}
+
+	return v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   dataVolumeName,
+			Labels: mongoLabels(mkResource),
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes:      []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			StorageClassName: storageClassName,
+			Resources: v1.VolumeResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceStorage: size},
+			},
+		},
+	}
+}
+
+// Compute resources of the MongoDB container
+func mongoResources(mkResource *beta1.Mk) v1.ResourceRequirements {
+	if mkResource.Spec.Resources != nil {
+		return *mkResource.Spec.Resources.DeepCopy()
+	}
+	return v1.ResourceRequirements{Requests: defaultMongoRequests.DeepCopy()}
+}
+
+// Copy of the template without the volume mounts of claims the statefulset does not have.
+// Volume claim templates cannot be changed, so a statefulset created without the data volume
+// keeps running without it until it is recreated.
+func withoutMissingClaims(template v1.PodTemplateSpec, claims []v1.PersistentVolumeClaim) v1.PodTemplateSpec {
+	volumes := map[string]bool{}
+	for _, volume := range template.Spec.Volumes {
+		volumes[volume.Name] = true
+	}
+	for _, claim := range claims {
+		volumes[claim.Name] = true
+	}
+
+	template = *template.DeepCopy()
+	for i := range template.Spec.Containers {
+		container := &template.Spec.Containers[i]
+		var mounts []v1.VolumeMount
+		for _, mount := range container.VolumeMounts {
+			if volumes[mount.Name] {
+				mounts = append(mounts, mount)
+			}
+		}
+		container.VolumeMounts = mounts
+	}
+	return template
+}
@@ -0,0 +1,80 @@
+package controller
+
+import (
+	"testing"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestDataVolumeClaim(t *testing.T) {
+	fast := "fast"
+	size := resource.MustParse("20Gi")
+
+	tests := []struct {
+		name      string
+		storage   *beta1.StorageSpec
+		wantSize  string
+		wantClass *string
+	}{
+		{name: "defaults", wantSize: "1Gi"},
+		{name: "empty", storage: &beta1.StorageSpec{}, wantSize: "1Gi"},
+		{name: "size and class", storage: &beta1.StorageSpec{Size: &size, StorageClassName: &fast}, wantSize: "20Gi", wantClass: &fast},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mkResource := testMk()
+			mkResource.Spec.Storage = tt.storage
+
+			claim := dataVolumeClaim(mkResource)
+			if got := claim.Spec.Resources.Requests[v1.ResourceStorage]; got.Cmp(resource.MustParse(tt.wantSize)) != 0 {
+				t.Errorf("size = %s, want %s", got.String(), tt.wantSize)
+			}
+			if (claim.Spec.StorageClassName == nil) != (tt.wantClass == nil) ||
+				(tt.wantClass != nil && *claim.Spec.StorageClassName != *tt.wantClass) {
+				t.Errorf("storage class = %v, want %v", claim.Spec.StorageClassName, tt.wantClass)
+			}
+		})
+	}
+}
+
+func TestMongoResources(t *testing.T) {
+	mkResource := testMk()
+	resources := mongoResources(mkResource)
+	if resources.Requests.Cpu().IsZero() || resources.Requests.Memory().IsZero() {
+		t.Errorf("no default requests: %v", resources)
+	}
+
+	mkResource.Spec.Resources = &v1.ResourceRequirements{Limits: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")}}
+	resources = mongoResources(mkResource)
+	if len(resources.Requests) != 0 || resources.Limits.Cpu().Cmp(resource.MustParse("2")) != 0 {
+		t.Errorf("spec.resources not used: %v", resources)
+	}
+}
+
+func TestStatefulSetMutatorWithoutDataClaim(t *testing.T) {
+	mkResource := testMk()
+	desired := testStatefulSet(mkResource, nil, nil)
+
+	// created before the statefulset got a volume claim template, which cannot be added later
+	existing := testStatefulSet(mkResource, nil, nil)
+	existing.Spec.VolumeClaimTemplates = nil
+	existing.Spec.Template = withoutMissingClaims(existing.Spec.Template, nil)
+	withServerDefaults(&existing.Spec.Template)
+	if statefulSetMutator(desired)(existing) {
+		t.Errorf("statefulset without volume claims is updated")
+	}
+
+	mkResource.Spec.Resources = &v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}}
+	if !statefulSetMutator(testStatefulSet(mkResource, nil, nil))(existing) {
+		t.Fatalf("changed resources are not noticed")
+	}
+	for _, mount := range existing.Spec.Template.Spec.Containers[0].VolumeMounts {
+		if mount.Name == dataVolumeName {
+			t.Errorf("data volume is mounted without a claim")
+		}
+	}
+}
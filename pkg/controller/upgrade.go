@@ -0,0 +1,209 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// MongoDB releases in the order they have to be upgraded through, no release may be skipped
+var mongoDbReleases = []string{"3.6", "4.0", "4.2", "4.4", "5.0", "6.0", "7.0", "8.0"}
+
+// major.minor at the start of an image tag, e.g. 6.0 for mongo:6.0.5-jammy
+var imageVersion = regexp.MustCompile(`^(\d+\.\d+)`)
+
+// State of a MongoDB version upgrade, reported in the status of the Mk resource
+type mongoDbUpgrade struct {
+	// image run by all members
+	current string
+	// image in spec.mongoDbImage
+	target string
+	// why the upgrade to the target is not allowed, empty otherwise
+	blocked string
+	// last step taken by the upgrade
+	step string
+	// last state of the rollout of the target image to the members
+	rollout rollout
+}
+
+// Whether members still have to be moved to the target image
+func (u *mongoDbUpgrade) inProgress() bool {
+	return u.blocked == "" && u.current != u.target
+}
+
+// Image the members should run, blocked upgrades keep the current one
+func (u *mongoDbUpgrade) image() string {
+	if u.blocked != "" {
+		return u.current
+	}
+	return u.target
+}
+
+// Message for status.upgrade, empty if there is no upgrade
+func (u *mongoDbUpgrade) message() string {
+	switch {
+	case u.blocked != "":
+		return fmt.Sprintf("Upgrade from %s to %s is blocked: %s", u.current, u.target, u.blocked)
+	case u.inProgress() && u.step != "":
+		return fmt.Sprintf("Upgrading from %s to %s: %s", u.current, u.target, u.step)
+	case u.inProgress():
+		return fmt.Sprintf("Upgrading from %s to %s", u.current, u.target)
+	}
+	return ""
+}
+
+// Record the upgrade in the status of the Mk resource
+func (u *mongoDbUpgrade) report(status *beta1.MkStatus) {
+	status.MongoDbImage = u.current
+	status.Upgrade = u.message()
+}
+
+// major.minor of the MongoDB release in the tag of an image
+func mongoDbVersion(image string) (string, bool) {
+	image, _, _ = strings.Cut(image, "@")
+	name := image[strings.LastIndex(image, "/")+1:]
+	_, tag, found := strings.Cut(name, ":")
+	if !found {
+		return "", false
+	}
+
+	match := imageVersion.FindStringSubmatch(tag)
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
+// Position of a release in mongoDbReleases, -1 if it is not supported
+func mongoDbRelease(version string) int {
+	for i, release := range mongoDbReleases {
+		if release == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// Check that the members can be moved from one image to the other. Patch releases can be
+// changed freely, otherwise only an upgrade to the next release is supported.
+func validateUpgrade(from, to string) error {
+	fromVersion, ok := mongoDbVersion(from)
+	if !ok {
+		return fmt.Errorf("the MongoDB version of image %s is unknown, use a tag starting with the version", from)
+	}
+	toVersion, ok := mongoDbVersion(to)
+	if !ok {
+		return fmt.Errorf("the MongoDB version of image %s is unknown, use a tag starting with the version", to)
+	}
+	if fromVersion == toVersion {
+		return nil
+	}
+
+	fromRelease, toRelease := mongoDbRelease(fromVersion), mongoDbRelease(toVersion)
+	if fromRelease < 0 {
+		return fmt.Errorf("upgrades from MongoDB %s are not supported", fromVersion)
+	}
+	if toRelease < 0 {
+		return fmt.Errorf("upgrades to MongoDB %s are not supported", toVersion)
+	}
+	if toRelease < fromRelease {
+		return fmt.Errorf("downgrades from MongoDB %s to %s are not supported", fromVersion, toVersion)
+	}
+	if toRelease > fromRelease+1 {
+		return fmt.Errorf("MongoDB %s has to be upgraded to %s first", fromVersion, mongoDbReleases[fromRelease+1])
+	}
+	return nil
+}
+
+// Compare the image run by the members with spec.mongoDbImage. The members run the image recorded
+// in the status, or for instances created before it was recorded, the image of the statefulset.
+func (c *Controller) planUpgrade(ctx context.Context, mkResource *beta1.Mk) (*mongoDbUpgrade, error) {
+	upgrade := &mongoDbUpgrade{
+		current: mkResource.Status.MongoDbImage,
+		target:  mkResource.Spec.MongoDbImage,
+	}
+
+	if upgrade.current == "" {
+		upgrade.current = upgrade.target
+		statefulSet, err := c.k8sclient.AppsV1().StatefulSets(mkResource.Namespace).Get(ctx, statefulSetName(mkResource), metav1.GetOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+		if err == nil {
+			if container := mongoContainer(mkResource, statefulSet); container != nil {
+				upgrade.current = container.Image
+			}
+		}
+	}
+
+	// The statefulset runs the images moved to the registry of the operator, so both are compared
+	// after moving them
+	registry := c.options.ImageRegistry
+	if overrideRegistry(upgrade.current, registry) == overrideRegistry(upgrade.target, registry) {
+		upgrade.current = upgrade.target
+		return upgrade, nil
+	}
+
+	if err := validateUpgrade(upgrade.current, upgrade.target); err != nil {
+		upgrade.blocked = err.Error()
+		if mkResource.Status.Upgrade != upgrade.message() && dryRunFrom(ctx) == nil {
+			c.recorder.Event(mkResource, v1.EventTypeWarning, reasonUpgradeBlocked, upgrade.message())
+		}
+		return upgrade, nil
+	}
+
+	if !strings.HasPrefix(mkResource.Status.Upgrade, "Upgrading") && dryRunFrom(ctx) == nil {
+		c.recorder.Eventf(mkResource, v1.EventTypeNormal, reasonUpgradeStarted, "Upgrading from %s to %s", upgrade.current, upgrade.target)
+	}
+
+	return upgrade, nil
+}
+
+// Move the members to the new image through rollMembers, then raise the feature compatibility
+// version to the new release. Returns true once the upgrade has finished.
+func (c *Controller) reconcileUpgrade(ctx context.Context, mkResource *beta1.Mk, statefulSet *appsv1.StatefulSet, upgrade *mongoDbUpgrade) (bool, error) {
+	logger := klog.FromContext(ctx)
+
+	rollout, err := c.rollMembers(ctx, mkResource, statefulSet, "with image "+upgrade.target)
+	upgrade.rollout = rollout
+	upgrade.step = rollout.step
+	if err != nil || !rollout.done {
+		return false, err
+	}
+
+	// All members run the new release, enable its features
+	version, ok := mongoDbVersion(upgrade.target)
+	if !ok {
+		return true, nil
+	}
+
+	out, err := c.mongoEval(ctx, mkResource, rollout.primaryPod, "print(db.adminCommand({getParameter: 1, featureCompatibilityVersion: 1}).featureCompatibilityVersion.version)")
+	if err != nil {
+		return false, err
+	}
+	if lastLine(out) == version {
+		return true, nil
+	}
+
+	logger.Info("Setting feature compatibility version", "version", version)
+	command := fmt.Sprintf("db.adminCommand({setFeatureCompatibilityVersion: %q})", version)
+	if mongoDbRelease(version) >= mongoDbRelease("7.0") {
+		// required from 7.0 on, as the change cannot be undone without support
+		command = fmt.Sprintf("db.adminCommand({setFeatureCompatibilityVersion: %q, confirm: true})", version)
+	}
+	if _, err := c.mongoEval(ctx, mkResource, rollout.primaryPod, mongoCommandScript(command)); err != nil {
+		return false, err
+	}
+	c.recorder.Eventf(mkResource, v1.EventTypeNormal, reasonFeatureCompatibilitySet, "Set feature compatibility version to %s", version)
+
+	return true, nil
+}
@@ -0,0 +1,172 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestMongoDbVersion(t *testing.T) {
+	tests := []struct {
+		image  string
+		want   string
+		wantOk bool
+	}{
+		{image: "mongo:7.0", want: "7.0", wantOk: true},
+		{image: "mongo:6.0.5-jammy", want: "6.0", wantOk: true},
+		{image: "registry.local:5000/library/mongo:5.0.24", want: "5.0", wantOk: true},
+		{image: "mongo:7.0.5@sha256:0123456789abcdef", want: "7.0", wantOk: true},
+		{image: "mongo"},
+		{image: "mongo:latest"},
+		{image: "registry.local:5000/mongo"},
+		{image: "mongo@sha256:0123456789abcdef"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			got, ok := mongoDbVersion(tt.image)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("mongoDbVersion() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestValidateUpgrade(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    string
+		to      string
+		wantErr string
+	}{
+		{name: "patch release", from: "mongo:7.0.2", to: "mongo:7.0.5"},
+		{name: "patch downgrade", from: "mongo:7.0.5", to: "mongo:7.0.2-jammy"},
+		{name: "next release", from: "mongo:6.0", to: "mongo:7.0"},
+		{name: "4.4 to 5.0", from: "mongo:4.4.25", to: "mongo:5.0"},
+		{name: "skipped release", from: "mongo:5.0", to: "mongo:7.0", wantErr: "MongoDB 5.0 has to be upgraded to 6.0 first"},
+		{name: "downgrade", from: "mongo:7.0", to: "mongo:6.0", wantErr: "downgrades from MongoDB 7.0 to 6.0"},
+		{name: "unknown source version", from: "mongo:latest", to: "mongo:7.0", wantErr: "image mongo:latest is unknown"},
+		{name: "unknown target version", from: "mongo:7.0", to: "mongo", wantErr: "image mongo is unknown"},
+		{name: "unsupported source release", from: "mongo:3.4", to: "mongo:3.6", wantErr: "upgrades from MongoDB 3.4"},
+		{name: "unsupported target release", from: "mongo:8.0", to: "mongo:9.0", wantErr: "upgrades to MongoDB 9.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateUpgrade(tt.from, tt.to)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMongoDbUpgradeReport(t *testing.T) {
+	tests := []struct {
+		name        string
+		upgrade     mongoDbUpgrade
+		wantImage   string
+		wantMessage string
+	}{
+		{
+			name:      "no upgrade",
+			upgrade:   mongoDbUpgrade{current: "mongo:7.0", target: "mongo:7.0"},
+			wantImage: "mongo:7.0",
+		},
+		{
+			name:        "in progress",
+			upgrade:     mongoDbUpgrade{current: "mongo:6.0", target: "mongo:7.0", step: "restarting test-mongodb-1"},
+			wantImage:   "mongo:7.0",
+			wantMessage: "Upgrading from mongo:6.0 to mongo:7.0: restarting test-mongodb-1",
+		},
+		{
+			name:        "blocked",
+			upgrade:     mongoDbUpgrade{current: "mongo:5.0", target: "mongo:7.0", blocked: "MongoDB 5.0 has to be upgraded to 6.0 first"},
+			wantImage:   "mongo:5.0",
+			wantMessage: "Upgrade from mongo:5.0 to mongo:7.0 is blocked: MongoDB 5.0 has to be upgraded to 6.0 first",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.upgrade.image(); got != tt.wantImage {
+				t.Errorf("image() = %q, want %q", got, tt.wantImage)
+			}
+
+			status := &beta1.MkStatus{}
+			tt.upgrade.report(status)
+			if status.MongoDbImage != tt.upgrade.current || status.Upgrade != tt.wantMessage {
+				t.Errorf("status = %q, %q, want %q, %q", status.MongoDbImage, status.Upgrade, tt.upgrade.current, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestPlanUpgrade(t *testing.T) {
+	tests := []struct {
+		name        string
+		registry    string
+		status      string
+		running     string
+		target      string
+		wantCurrent string
+		wantBlocked bool
+	}{
+		{name: "new instance", target: "mongo:7.0", wantCurrent: "mongo:7.0"},
+		{name: "recorded image", status: "mongo:6.0", running: "mongo:6.0", target: "mongo:7.0", wantCurrent: "mongo:6.0"},
+		{name: "image of the statefulset", running: "mongo:6.0", target: "mongo:7.0", wantCurrent: "mongo:6.0"},
+		{
+			name:        "statefulset with the registry of the operator",
+			registry:    "mirror.example.com",
+			running:     "mirror.example.com/library/mongo:7.0",
+			target:      "mongo:7.0",
+			wantCurrent: "mongo:7.0",
+		},
+		{
+			name:        "upgrade with the registry of the operator",
+			registry:    "mirror.example.com",
+			running:     "mirror.example.com/library/mongo:6.0",
+			target:      "mongo:7.0",
+			wantCurrent: "mirror.example.com/library/mongo:6.0",
+		},
+		{name: "skipped release", status: "mongo:5.0", target: "mongo:7.0", wantCurrent: "mongo:5.0", wantBlocked: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mkResource := testMk()
+			mkResource.Spec.MongoDbImage = tt.target
+			mkResource.Status.MongoDbImage = tt.status
+
+			var objects []runtime.Object
+			if tt.running != "" {
+				statefulSet := testStatefulSet(mkResource, nil, nil)
+				mongoContainer(mkResource, statefulSet).Image = tt.running
+				objects = append(objects, statefulSet)
+			}
+			c, _ := testController(mkResource, objects...)
+			c.options.ImageRegistry = tt.registry
+
+			upgrade, err := c.planUpgrade(context.Background(), mkResource)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if upgrade.current != tt.wantCurrent || upgrade.target != tt.target {
+				t.Errorf("planUpgrade() = %s to %s, want %s to %s", upgrade.current, upgrade.target, tt.wantCurrent, tt.target)
+			}
+			if blocked := upgrade.blocked != ""; blocked != tt.wantBlocked {
+				t.Errorf("blocked = %q, want blocked %v", upgrade.blocked, tt.wantBlocked)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+// Package metrics holds the Prometheus metrics of the operator, they are served on /metrics
+package metrics
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	mklister "mongokube/pkg/client/listers/mongokube/beta1"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"k8s.io/apimachinery/pkg/labels"
+	clientmetrics "k8s.io/client-go/tools/metrics"
+)
+
+const namespace = "mongokube"
+
+// Results of a reconcile
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+)
+
+// Registry contains all metrics of the operator
+var Registry = prometheus.NewRegistry()
+
+var (
+	reconcileTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconcile_total",
+		Help:      "Number of reconciles of Mk resources by result.",
+	}, []string{"result"})
+
+	reconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Duration of reconciles of Mk resources by result.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"result"})
+
+	requestLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rest_client_request_duration_seconds",
+		Help:      "Latency of requests to the Kubernetes API server by verb and host.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"verb", "host"})
+
+	leader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "leader",
+		Help:      "Whether this replica is the leader running the workers, 1 if it is, otherwise 0.",
+	})
+
+	requestResult = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rest_client_requests_total",
+		Help:      "Number of requests to the Kubernetes API server by status code, method and host.",
+	}, []string{"code", "method", "host"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		reconcileTotal,
+		reconcileDuration,
+		requestLatency,
+		requestResult,
+		leader,
+	)
+
+	clientmetrics.Register(clientmetrics.RegisterOpts{
+		RequestLatency: &latencyAdapter{},
+		RequestResult:  &resultAdapter{},
+	})
+}
+
+// ObserveReconcile records the result and the duration of a reconcile
+func ObserveReconcile(result string, duration time.Duration) {
+	reconcileTotal.WithLabelValues(result).Inc()
+	reconcileDuration.WithLabelValues(result).Observe(duration.Seconds())
+}
+
+// SetLeader records whether this replica is the leader
+func SetLeader(leading bool) {
+	if leading {
+		leader.Set(1)
+	} else {
+		leader.Set(0)
+	}
+}
+
+// latencyAdapter passes the request latencies of client-go on to Prometheus
+type latencyAdapter struct{}
+
+func (l *latencyAdapter) Observe(_ context.Context, verb string, u url.URL, latency time.Duration) {
+	requestLatency.WithLabelValues(verb, u.Host).Observe(latency.Seconds())
+}
+
+// resultAdapter passes the request results of client-go on to Prometheus
+type resultAdapter struct{}
+
+func (r *resultAdapter) Increment(_ context.Context, code, method, host string) {
+	requestResult.WithLabelValues(code, method, host).Inc()
+}
+
+// mkCollector counts the Mk resources in the informer cache by status.progress when scraped
+type mkCollector struct {
+	lister mklister.MkLister
+	desc   *prometheus.Desc
+}
+
+// NewMkCollector returns a collector reporting the number of managed Mk resources by phase
+func NewMkCollector(lister mklister.MkLister) prometheus.Collector {
+	return &mkCollector{
+		lister: lister,
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "mk_instances"),
+			"Number of managed Mk resources by phase.",
+			[]string{"phase"}, nil,
+		),
+	}
+}
+
+func (m *mkCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- m.desc
+}
+
+func (m *mkCollector) Collect(ch chan<- prometheus.Metric) {
+	mks, err := m.lister.List(labels.Everything())
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(m.desc, err)
+		return
+	}
+
+	phases := map[string]int{}
+	for _, mk := range mks {
+		phase := mk.Status.Progress
+		if phase == "" {
+			phase = "Pending"
+		}
+		phases[phase]++
+	}
+
+	for phase, count := range phases {
+		ch <- prometheus.MustNewConstMetric(m.desc, prometheus.GaugeValue, float64(count), phase)
+	}
+}
@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+	mklister "mongokube/pkg/client/listers/mongokube/beta1"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestObserveReconcile(t *testing.T) {
+	successes := testutil.ToFloat64(reconcileTotal.WithLabelValues(ResultSuccess))
+	failures := testutil.ToFloat64(reconcileTotal.WithLabelValues(ResultError))
+
+	ObserveReconcile(ResultSuccess, time.Second)
+	ObserveReconcile(ResultSuccess, time.Second)
+	ObserveReconcile(ResultError, time.Second)
+
+	if got := testutil.ToFloat64(reconcileTotal.WithLabelValues(ResultSuccess)) - successes; got != 2 {
+		t.Errorf("recorded %v successful reconciles, want 2", got)
+	}
+	if got := testutil.ToFloat64(reconcileTotal.WithLabelValues(ResultError)) - failures; got != 1 {
+		t.Errorf("recorded %v failed reconciles, want 1", got)
+	}
+	if got := testutil.CollectAndCount(reconcileDuration, "mongokube_reconcile_duration_seconds"); got != 2 {
+		t.Errorf("recorded durations for %d results, want 2", got)
+	}
+}
+
+func TestSetLeader(t *testing.T) {
+	SetLeader(true)
+	if got := testutil.ToFloat64(leader); got != 1 {
+		t.Errorf("leader = %v while leading, want 1", got)
+	}
+	SetLeader(false)
+	if got := testutil.ToFloat64(leader); got != 0 {
+		t.Errorf("leader = %v while standing by, want 0", got)
+	}
+}
+
+func TestMkCollector(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for name, progress := range map[string]string{"a": "Running", "b": "Running", "c": "Paused", "d": ""} {
+		indexer.Add(&beta1.Mk{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Status:     beta1.MkStatus{Progress: progress},
+		})
+	}
+
+	want := `# HELP mongokube_mk_instances Number of managed Mk resources by phase.
+# TYPE mongokube_mk_instances gauge
+mongokube_mk_instances{phase="Paused"} 1
+mongokube_mk_instances{phase="Pending"} 1
+mongokube_mk_instances{phase="Running"} 2
+`
+	collector := NewMkCollector(mklister.NewMkLister(indexer))
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
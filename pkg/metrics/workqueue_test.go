@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestWorkqueueMetrics(t *testing.T) {
+	queue := workqueue.NewRateLimitingQueueWithConfig(workqueue.DefaultControllerRateLimiter(),
+		workqueue.RateLimitingQueueConfig{Name: "test"})
+	defer queue.ShutDown()
+
+	queue.Add("default/a")
+	queue.Add("default/b")
+	queue.Add("default/a")
+	if got := testutil.ToFloat64(workqueueDepth.WithLabelValues("test")); got != 2 {
+		t.Errorf("depth = %v after adding two items, want 2", got)
+	}
+	if got := testutil.ToFloat64(workqueueAdds.WithLabelValues("test")); got != 2 {
+		t.Errorf("adds = %v, want 2 as the duplicate is not added", got)
+	}
+
+	item, _ := queue.Get()
+	if got := testutil.ToFloat64(workqueueDepth.WithLabelValues("test")); got != 1 {
+		t.Errorf("depth = %v while an item is processed, want 1", got)
+	}
+	queue.AddRateLimited(item)
+	queue.Done(item)
+	if got := testutil.ToFloat64(workqueueRetries.WithLabelValues("test")); got != 1 {
+		t.Errorf("retries = %v, want 1", got)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"mongokube/pkg/apis/mongokube/beta1"
+	"mongokube/pkg/controller"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+// renderCommand implements `mongokube render -f mongo.yaml`, which prints the objects the
+// controller would create for the Mk resources in a file to stdout, without a cluster
+func renderCommand(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	file := flags.String("f", "", "File with Mk resources, - for stdin")
+	namespace := flags.String("namespace", metav1.NamespaceDefault, "Namespace of Mk resources which do not set one")
+	registry := flags.String("image-registry", "", "Pull all images from this registry, as with the flag of the controller")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: mongokube render -f mongo.yaml [flags]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		flags.Usage()
+		return errors.New("-f is required")
+	}
+
+	in := os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	mkResources, err := readMks(in)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", *file, err)
+	}
+	if len(mkResources) == 0 {
+		return fmt.Errorf("%s holds no Mk resources", *file)
+	}
+
+	separator := ""
+	for _, mkResource := range mkResources {
+		if mkResource.Namespace == "" {
+			mkResource.Namespace = *namespace
+		}
+
+		objects, err := controller.Render(mkResource, *registry)
+		if err != nil {
+			return fmt.Errorf("failed to render Mk %s: %w", mkResource.Name, err)
+		}
+
+		for _, obj := range objects {
+			out, err := yaml.Marshal(obj)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(stdout, "%s%s", separator, out)
+			separator = "---\n"
+		}
+	}
+	return nil
+}
+
+// Mk resources in a YAML or JSON stream, other documents are skipped
+func readMks(in io.Reader) ([]*beta1.Mk, error) {
+	var mkResources []*beta1.Mk
+
+	decoder := utilyaml.NewYAMLOrJSONDecoder(in, 4096)
+	for {
+		mkResource := &beta1.Mk{}
+		err := decoder.Decode(mkResource)
+		if errors.Is(err, io.EOF) {
+			return mkResources, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if mkResource.APIVersion == beta1.SchemeGroupVersion.String() && mkResource.Kind == "Mk" {
+			mkResources = append(mkResources, mkResource)
+		}
+	}
+}
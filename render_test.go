@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderCommand(t *testing.T) {
+	var first, second bytes.Buffer
+	if err := renderCommand([]string{"-f", "manifests/mongo.yaml"}, &first); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := renderCommand([]string{"-f", "manifests/mongo.yaml"}, &second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if first.String() != second.String() {
+		t.Errorf("two renders of the same file differ")
+	}
+	for _, want := range []string{"kind: StatefulSet", "name: mongokube-test-mongodb", "namespace: mongokube-ns", "---\n"} {
+		if !strings.Contains(first.String(), want) {
+			t.Errorf("output does not contain %q", want)
+		}
+	}
+	// the base64 encoding of REDACTED, the manifest uses admin as username and password
+	if !strings.Contains(first.String(), "password: UkVEQUNURUQ=") {
+		t.Errorf("dbPassword is not redacted:\n%s", first.String())
+	}
+}
+
+func TestReadMks(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "mongo.yaml")
+	content := `apiVersion: v1
+kind: Namespace
+metadata:
+  name: mongo
+---
+apiVersion: mongokube.wrd/beta1
+kind: Mk
+metadata:
+  name: first
+---
+{"apiVersion": "mongokube.wrd/beta1", "kind": "Mk", "metadata": {"name": "second"}}
+`
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	in, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+
+	mkResources, err := readMks(in)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(mkResources) != 2 || mkResources[0].Name != "first" || mkResources[1].Name != "second" {
+		t.Errorf("readMks() = %v, want Mk first and second", mkResources)
+	}
+
+	var out bytes.Buffer
+	if err := renderCommand([]string{"-f", file, "--namespace", "mongo"}, &out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(out.String(), "namespace: mongo\n") {
+		t.Errorf("Mk resources without a namespace are not rendered in --namespace")
+	}
+}
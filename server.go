@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net/http"
+	"time"
+
+	"mongokube/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/klog/v2"
+)
+
+var metricsBindAddress = flag.String("metrics-bind-address", ":8080", "Address the /metrics endpoint binds to, 0 disables it")
+
+// Serve the handler on addr until ctx is cancelled, the server runs on every replica
+// regardless of leader election
+func serve(ctx context.Context, name string, addr string, handler http.Handler) {
+	if addr == "0" {
+		return
+	}
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	logger := klog.FromContext(ctx).WithValues("server", name, "address", addr)
+	go func() {
+		logger.Info("Serving")
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error(err, "Error serving")
+		}
+	}()
+}
+
+// Handler for the metrics endpoint
+func metricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}))
+	return mux
+}